@@ -1,20 +1,34 @@
 package env
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/antonybholmes/go-sys/log"
 	"github.com/joho/godotenv"
 )
 
+// Importing the package loads .env and lets it override the real
+// environment for backwards compatibility. Set ENV_AUTOLOAD=false
+// to opt out and use a Loader explicitly instead.
 func init() {
+	if !autoload() {
+		log.Debug().Msgf("env autoload disabled")
+		return
+	}
+
 	log.Debug().Msgf("loading envs...")
 
 	// force reloading
-	err := godotenv.Overload()
+	err := NewLoader().
+		WithFiles(DefaultEnvFile).
+		WithPrecedence(FilesFirst).
+		Load()
 
 	if err != nil {
 		log.Debug().Msgf("error loading .env file(s)")
@@ -37,8 +51,17 @@ func Ls() {
 	}
 }
 
+// Get returns the value of a variable. If it is not set directly,
+// the secret providers are asked, so by default DB_PASSWORD can
+// come from the file named in DB_PASSWORD_FILE.
 func Get(name string) string {
-	return os.Getenv(name)
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	v, _ := secrets.lookup(name)
+
+	return v
 }
 
 func GetStr(name string, def string) string {
@@ -51,18 +74,10 @@ func GetStr(name string, def string) string {
 	return def
 }
 
-func GetUint32(name string, def uint) uint {
-	v := Get(name)
-
-	if v != "" {
-		c, err := strconv.ParseUint(v, 10, 32)
-
-		if err == nil {
-			return uint(c)
-		}
-	}
-
-	return def
+// GetUint32 reads an unsigned 32 bit int or returns a default if
+// the variable is not set or invalid
+func GetUint32(name string, def uint32) uint32 {
+	return getVar(name, def, parseUint32)
 }
 
 // Interpret an env variable as a duration or return
@@ -75,17 +90,22 @@ func GetHour(name string, def time.Duration) time.Duration {
 	return GetTime(name, time.Hour, def)
 }
 
+// GetTime reads a duration where plain integers are multiplied by
+// unit, so with a unit of time.Minute both 90 and 1h30m are accepted
 func GetTime(name string, unit time.Duration, def time.Duration) time.Duration {
-	v := Get(name)
-
-	if v != "" {
-		c, err := strconv.ParseInt(v, 10, 32)
+	return getVar(name, def, func(v string) (time.Duration, error) {
+		c, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
 
 		if err == nil {
 			log.Debug().Msgf("found %v with value %d", name, c)
-			return time.Duration(c) * unit
+
+			if unit > 0 && (c > math.MaxInt64/int64(unit) || c < math.MinInt64/int64(unit)) {
+				return 0, fmt.Errorf("%d %v is out of range", c, unit)
+			}
+
+			return time.Duration(c) * unit, nil
 		}
-	}
 
-	return def
+		return ParseDuration(v)
+	})
 }
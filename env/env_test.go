@@ -0,0 +1,304 @@
+package env
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeEnvFile(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderLayers(t *testing.T) {
+	dir := t.TempDir()
+
+	writeEnvFile(t, dir, ".env", "LOADER_A=base\nLOADER_B=base\nLOADER_C=base\n")
+	writeEnvFile(t, dir, ".env.test", "LOADER_B=test\nLOADER_C=test\n")
+	writeEnvFile(t, dir, ".env.local", "LOADER_C=local\n")
+
+	values, err := NewLoader().WithDir(dir).WithAppEnv("test").Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"LOADER_A": "base", "LOADER_B": "test", "LOADER_C": "local"}
+
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s = %q; want %q", k, values[k], v)
+		}
+	}
+}
+
+func TestLoaderPrecedence(t *testing.T) {
+	dir := t.TempDir()
+
+	writeEnvFile(t, dir, ".env", "LOADER_REAL=file\n")
+
+	t.Setenv("LOADER_REAL", "real")
+
+	// load twice to check that the real value is remembered
+	// once the loader has taken over the variable
+	for range 2 {
+		err := NewLoader().WithDir(dir).Load()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v := Get("LOADER_REAL"); v != "real" {
+			t.Errorf("EnvFirst: LOADER_REAL = %q; want %q", v, "real")
+		}
+	}
+
+	err := NewLoader().WithDir(dir).WithPrecedence(FilesFirst).Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := Get("LOADER_REAL"); v != "file" {
+		t.Errorf("FilesFirst: LOADER_REAL = %q; want %q", v, "file")
+	}
+}
+
+func TestFileSecret(t *testing.T) {
+	dir := t.TempDir()
+
+	writeEnvFile(t, dir, "db", "s3cret\n")
+
+	t.Setenv("SECRET_DB_PASSWORD_FILE", filepath.Join(dir, "db"))
+	ReloadSecrets()
+
+	if v := Get("SECRET_DB_PASSWORD"); v != "s3cret" {
+		t.Errorf("SECRET_DB_PASSWORD = %q; want %q", v, "s3cret")
+	}
+
+	// secrets are cached until reloaded
+	writeEnvFile(t, dir, "db", "rotated\n")
+
+	if v := Get("SECRET_DB_PASSWORD"); v != "s3cret" {
+		t.Errorf("cached SECRET_DB_PASSWORD = %q; want %q", v, "s3cret")
+	}
+
+	ReloadSecrets()
+
+	if v := Get("SECRET_DB_PASSWORD"); v != "rotated" {
+		t.Errorf("reloaded SECRET_DB_PASSWORD = %q; want %q", v, "rotated")
+	}
+
+	// a value set directly wins over the file
+	t.Setenv("SECRET_DB_PASSWORD", "direct")
+
+	if v := Get("SECRET_DB_PASSWORD"); v != "direct" {
+		t.Errorf("SECRET_DB_PASSWORD = %q; want %q", v, "direct")
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+
+	writeEnvFile(t, dir, ".env", "WATCH_A=1\nWATCH_B=1\n")
+
+	w := NewWatcher(NewLoader().WithDir(dir).WithFiles(".env"), time.Hour)
+
+	err := w.Start()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer w.Stop()
+
+	changes := w.Subscribe(1)
+
+	writeEnvFile(t, dir, ".env", "WATCH_A=2\nWATCH_C=1\n")
+
+	// make sure the poll sees the change even on coarse file systems
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, ".env"), future, future)
+
+	_, err = w.Check()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Change{
+		{Name: "WATCH_A", Old: "1", New: "2", Kind: Updated},
+		{Name: "WATCH_B", Old: "1", Kind: Removed},
+		{Name: "WATCH_C", New: "1", Kind: Added},
+	}
+
+	got := <-changes
+
+	if !slices.Equal(got, want) {
+		t.Errorf("changes = %v; want %v", got, want)
+	}
+
+	if _, ok := os.LookupEnv("WATCH_B"); ok {
+		t.Errorf("WATCH_B should have been unset")
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := NewSchema()
+
+	schema.Declare("SCHEMA_PORT", Int).WithDefault("8080").WithRange(1, 65535)
+	schema.Declare("SCHEMA_MODE", String).WithEnum("dev", "prod").WithRequired()
+	schema.Declare("SCHEMA_NAME", String).WithPattern(`^[a-z]+$`).WithDefault("app")
+
+	t.Setenv("SCHEMA_PORT", "70000")
+
+	err := schema.Validate()
+
+	var verr *ValidationError
+
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() = %v; want ValidationError", err)
+	}
+
+	for _, name := range []string{"SCHEMA_PORT", "SCHEMA_MODE"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Validate() = %v; want error for %s", err, name)
+		}
+	}
+
+	if strings.Contains(err.Error(), "SCHEMA_NAME") {
+		t.Errorf("Validate() = %v; SCHEMA_NAME should be valid", err)
+	}
+
+	t.Setenv("SCHEMA_PORT", "443")
+	t.Setenv("SCHEMA_MODE", "prod")
+
+	if err := schema.Validate(); err != nil {
+		t.Errorf("Validate() = %v; want nil", err)
+	}
+
+	// values are parsed like the getters parse them
+	t.Setenv("SCHEMA_PORT", " 443 ")
+
+	if err := schema.Validate(); err != nil || GetInt("SCHEMA_PORT", 0) != 443 {
+		t.Errorf("Validate(SCHEMA_PORT=\" 443 \") = %v", err)
+	}
+
+	// durations are compared in seconds
+	schema.Declare("SCHEMA_TIMEOUT", Duration).WithRange(1, 60)
+
+	for value, valid := range map[string]bool{"30s": true, "PT1M": true, "500ms": false, "2m": false} {
+		t.Setenv("SCHEMA_TIMEOUT", value)
+
+		if err := schema.Validate(); (err == nil) != valid {
+			t.Errorf("Validate(SCHEMA_TIMEOUT=%s) = %v", value, err)
+		}
+	}
+
+	// ranges make no sense for strings and bools
+	for _, typ := range []VarType{String, Bool} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithMax on a %s should panic", typ)
+				}
+			}()
+
+			schema.Declare("SCHEMA_FLAG", typ).WithMax(0)
+		}()
+	}
+
+	var b strings.Builder
+
+	schema.WriteSample(&b)
+
+	if !strings.Contains(b.String(), "SCHEMA_PORT=8080\n") {
+		t.Errorf("sample missing default:\n%s", b.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	durations := map[string]time.Duration{
+		"1h30m":   90 * time.Minute,
+		"PT5M":    5 * time.Minute,
+		"P1DT12H": 36 * time.Hour,
+		"PT0.5S":  500 * time.Millisecond,
+	}
+
+	for s, want := range durations {
+		if d, err := ParseDuration(s); err != nil || d != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", s, d, err, want)
+		}
+	}
+
+	for _, s := range []string{"P", "PT", "P1Y", "5"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("ParseDuration(%q) should fail", s)
+		}
+	}
+
+	sizes := map[string]int64{
+		"512":    512,
+		"10MB":   10_000_000,
+		"1GiB":   1 << 30,
+		"1.5 kb": 1500,
+	}
+
+	for s, want := range sizes {
+		if n, err := ParseSize(s); err != nil || n != want {
+			t.Errorf("ParseSize(%q) = %v, %v; want %v", s, n, err, want)
+		}
+	}
+
+	// floats of MaxInt64 round up to 2^63, which does not fit
+	if d, err := ParseDuration("PT2562047H47M16.854775808S"); err == nil {
+		t.Errorf("ParseDuration(2^63ns) = %v; want error", d)
+	}
+
+	if n, err := ParseSize("9223372036854775808"); err == nil {
+		t.Errorf("ParseSize(2^63) = %v; want error", n)
+	}
+
+	if p, err := ParsePercent("25%"); err != nil || p != 0.25 {
+		t.Errorf("ParsePercent(25%%) = %v, %v; want 0.25", p, err)
+	}
+
+	if b, err := ParseBool("Yes"); err != nil || !b {
+		t.Errorf("ParseBool(Yes) = %v, %v; want true", b, err)
+	}
+}
+
+func TestGetErr(t *testing.T) {
+	t.Setenv("PARSE_TIMEOUT", "soon")
+
+	d, err := GetDurationErr("PARSE_TIMEOUT", time.Second)
+
+	var perr *ParseError
+
+	if !errors.As(err, &perr) || d != time.Second {
+		t.Errorf("GetDurationErr() = %v, %v; want default and ParseError", d, err)
+	}
+
+	t.Setenv("PARSE_TIMEOUT", "90")
+
+	if d := GetMin("PARSE_TIMEOUT", 0); d != 90*time.Minute {
+		t.Errorf("GetMin() = %v; want %v", d, 90*time.Minute)
+	}
+
+	// too many hours to fit in a duration
+	t.Setenv("PARSE_TIMEOUT", "2147483647")
+
+	if d := GetHour("PARSE_TIMEOUT", time.Hour); d != time.Hour {
+		t.Errorf("GetHour() = %v; want the default", d)
+	}
+}
@@ -0,0 +1,261 @@
+package env
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// Precedence decides who wins when a variable is defined both
+// in a .env file and in the real process environment
+type Precedence int
+
+const (
+	// The real process environment always wins and files only
+	// fill in variables that are not already set. This is the
+	// safe choice for production containers.
+	EnvFirst Precedence = iota
+
+	// Files override the real process environment. This is how
+	// the package init() has always behaved.
+	FilesFirst
+)
+
+const (
+	// Name of the variable used to select the .env.<APP_ENV> layer
+	AppEnvVar = "APP_ENV"
+
+	// Set to false, 0, no, off etc. to stop the package init()
+	// loading .env when the package is imported
+	AutoloadVar = "ENV_AUTOLOAD"
+
+	DefaultEnvFile = ".env"
+	LocalEnvFile   = ".env.local"
+)
+
+// Loader reads layers of .env files and applies them to
+// the process environment. Later layers override earlier
+// ones and the real environment is layered according to
+// the precedence.
+type Loader struct {
+	dir        string
+	appEnv     string
+	files      []string
+	precedence Precedence
+}
+
+// keeps track of the process environment as it was before
+// any files were loaded so that we can tell real variables
+// apart from the ones we set ourselves
+type envState struct {
+	mu      sync.Mutex
+	process map[string]string
+	managed map[string]bool
+}
+
+var state = envState{
+	process: environ(),
+	managed: make(map[string]bool),
+}
+
+// NewLoader creates a loader that reads .env, .env.<APP_ENV>
+// and .env.local from the working directory and gives the real
+// environment precedence over all of them.
+func NewLoader() *Loader {
+	return &Loader{dir: ".", precedence: EnvFirst}
+}
+
+// WithDir sets the directory the default layers are read from
+func (l *Loader) WithDir(dir string) *Loader {
+	l.dir = dir
+	return l
+}
+
+// WithAppEnv sets the environment name used to pick the
+// .env.<APP_ENV> layer rather than looking it up
+func (l *Loader) WithAppEnv(appEnv string) *Loader {
+	l.appEnv = appEnv
+	return l
+}
+
+// WithFiles replaces the default layers with an explicit list
+// of files, read in order so that later files win. Relative
+// paths are resolved against the loader directory.
+func (l *Loader) WithFiles(files ...string) *Loader {
+	l.files = files
+	return l
+}
+
+func (l *Loader) WithPrecedence(precedence Precedence) *Loader {
+	l.precedence = precedence
+	return l
+}
+
+// Layers returns the files the loader will read, in the order
+// they are applied
+func (l *Loader) Layers() []string {
+	if len(l.files) > 0 {
+		return l.paths(l.files...)
+	}
+
+	names := []string{DefaultEnvFile}
+
+	if appEnv := l.resolveAppEnv(); appEnv != "" {
+		names = append(names, DefaultEnvFile+"."+appEnv)
+	}
+
+	names = append(names, LocalEnvFile)
+
+	return l.paths(names...)
+}
+
+// Read merges the layers into a single map without touching
+// the process environment. Missing files are skipped.
+func (l *Loader) Read() (map[string]string, error) {
+	return readLayers(l.Layers())
+}
+
+// Load reads the layers and applies them to the process
+// environment according to the loader precedence
+func (l *Loader) Load() error {
+	values, err := l.Read()
+
+	if err != nil {
+		return err
+	}
+
+	state.apply(values, l.precedence)
+
+	// new values may point to different secret files
+	ReloadSecrets()
+
+	return nil
+}
+
+func (l *Loader) paths(names ...string) []string {
+	ret := make([]string, 0, len(names))
+
+	for _, name := range names {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(l.dir, name)
+		}
+
+		ret = append(ret, name)
+	}
+
+	return ret
+}
+
+// The app env can be set explicitly, in the real environment
+// or in the base .env file, in that order
+func (l *Loader) resolveAppEnv() string {
+	if l.appEnv != "" {
+		return l.appEnv
+	}
+
+	if v, ok := state.real(AppEnvVar); ok && v != "" {
+		return v
+	}
+
+	values, err := godotenv.Read(l.paths(DefaultEnvFile)[0])
+
+	if err != nil {
+		return ""
+	}
+
+	return values[AppEnvVar]
+}
+
+func readLayers(files []string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	for _, file := range files {
+		layer, err := godotenv.Read(file)
+
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		for k, v := range layer {
+			values[k] = v
+		}
+	}
+
+	return values, nil
+}
+
+// Returns the value a variable has in the real environment, i.e.
+// either from when the process started or set later by the program
+// itself rather than by a loader
+func (s *envState) real(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.realLocked(name)
+}
+
+func (s *envState) realLocked(name string) (string, bool) {
+	if v, ok := s.process[name]; ok {
+		return v, true
+	}
+
+	if s.managed[name] {
+		return "", false
+	}
+
+	return os.LookupEnv(name)
+}
+
+func (s *envState) apply(values map[string]string, precedence Precedence) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range values {
+		if !s.managed[k] {
+			// remember the real value before we take over the variable
+			// so later loads can still give it precedence
+			if rv, ok := os.LookupEnv(k); ok {
+				if _, ok := s.process[k]; !ok {
+					s.process[k] = rv
+				}
+			}
+		}
+
+		if precedence == EnvFirst {
+			if rv, ok := s.realLocked(k); ok {
+				v = rv
+			}
+		}
+
+		os.Setenv(k, v)
+		s.managed[k] = true
+	}
+}
+
+func environ() map[string]string {
+	ret := make(map[string]string)
+
+	for _, e := range os.Environ() {
+		k, v, _ := strings.Cut(e, "=")
+		ret[k] = v
+	}
+
+	return ret
+}
+
+// autoload reports whether the package init() should load .env,
+// which it does unless explicitly disabled in the real environment
+func autoload() bool {
+	v, err := ParseBool(os.Getenv(AutoloadVar))
+
+	return err != nil || v
+}
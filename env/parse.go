@@ -0,0 +1,316 @@
+package env
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antonybholmes/go-sys/log"
+)
+
+// ParseError is returned when a variable is set but its value
+// cannot be parsed as the requested type
+type ParseError struct {
+	Err   error
+	Name  string
+	Value string
+}
+
+var (
+	isoDurationRegex = regexp.MustCompile(`^P(?:(\d+(?:[.,]\d+)?)W)?(?:(\d+(?:[.,]\d+)?)D)?(?:T(?:(\d+(?:[.,]\d+)?)H)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+	sizeRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-zA-Z]*)$`)
+
+	// decimal units are powers of 1000 and binary units
+	// powers of 1024 as per IEC and Kubernetes
+	sizeUnits = map[string]float64{
+		"":    1,
+		"b":   1,
+		"k":   1e3,
+		"kb":  1e3,
+		"m":   1e6,
+		"mb":  1e6,
+		"g":   1e9,
+		"gb":  1e9,
+		"t":   1e12,
+		"tb":  1e12,
+		"p":   1e15,
+		"pb":  1e15,
+		"ki":  1 << 10,
+		"kib": 1 << 10,
+		"mi":  1 << 20,
+		"mib": 1 << 20,
+		"gi":  1 << 30,
+		"gib": 1 << 30,
+		"ti":  1 << 40,
+		"tib": 1 << 40,
+		"pi":  1 << 50,
+		"pib": 1 << 50,
+	}
+)
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid value for %s %q: %v", e.Name, e.Value, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseDuration accepts Go durations such as 1h30m and ISO-8601
+// durations such as PT5M or P1DT12H. ISO years and months are not
+// supported since their length varies.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	if len(s) > 0 && (s[0] == 'P' || s[0] == 'p') {
+		return parseISODuration(strings.ToUpper(s))
+	}
+
+	return time.ParseDuration(s)
+}
+
+func parseISODuration(s string) (time.Duration, error) {
+	matches := isoDurationRegex.FindStringSubmatch(s)
+
+	// P and PT on their own are not valid durations
+	if matches == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid ISO-8601 duration %q", s)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+
+	var d float64
+
+	for i, unit := range units {
+		if matches[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(strings.Replace(matches[i+1], ",", ".", 1), 64)
+
+		if err != nil {
+			return 0, err
+		}
+
+		d += n * float64(unit)
+	}
+
+	// MaxInt64 rounds up to 2^63 as a float so this
+	// rejects everything that does not fit
+	if d >= math.MaxInt64 {
+		return 0, fmt.Errorf("ISO-8601 duration %q out of range", s)
+	}
+
+	return time.Duration(d), nil
+}
+
+// ParseSize parses byte sizes such as 512, 10MB or 1GiB. Decimal
+// units (KB, MB, ...) are powers of 1000 and binary units (KiB,
+// MiB, ...) powers of 1024. Units are case insensitive.
+func ParseSize(s string) (int64, error) {
+	matches := sizeRegex.FindStringSubmatch(strings.TrimSpace(s))
+
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit, ok := sizeUnits[strings.ToLower(matches[2])]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q", matches[2])
+	}
+
+	n, err := strconv.ParseFloat(matches[1], 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	size := n * unit
+
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+
+	return int64(size), nil
+}
+
+// ParseBool accepts the usual spellings of booleans, e.g.
+// true/false, yes/no, on/off, y/n, 1/0, enabled/disabled,
+// in any case
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "t", "true", "y", "yes", "on", "enable", "enabled":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off", "disable", "disabled":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid bool %q", s)
+	}
+}
+
+// ParsePercent returns a percentage as a fraction so 50% is 0.5.
+// Values without a percent sign are taken to be fractions already.
+func ParsePercent(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		n, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+
+		if err != nil {
+			return 0, fmt.Errorf("invalid percentage %q", s)
+		}
+
+		return n / 100, nil
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+
+	return n, nil
+}
+
+func parseInt(s string) (int, error) {
+	return strconv.Atoi(strings.TrimSpace(s))
+}
+
+func parseUint32(s string) (uint32, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+
+	return uint32(n), err
+}
+
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+// Parses a variable, returning the default if it is not set
+// and an error if it is set but cannot be parsed
+func parseVar[T any](name string, def T, parse func(string) (T, error)) (T, error) {
+	v := Get(name)
+
+	if v == "" {
+		return def, nil
+	}
+
+	ret, err := parse(v)
+
+	if err != nil {
+		return def, &ParseError{Name: name, Value: v, Err: err}
+	}
+
+	return ret, nil
+}
+
+// Like parseVar but logs errors and returns the default
+func getVar[T any](name string, def T, parse func(string) (T, error)) T {
+	ret, err := parseVar(name, def, parse)
+
+	if err != nil {
+		log.Warn().Msgf("%v, using default %v", err, def)
+	}
+
+	return ret
+}
+
+// Like parseVar but panics on errors so that services
+// fail fast at startup on bad configuration
+func mustGetVar[T any](name string, def T, parse func(string) (T, error)) T {
+	ret, err := parseVar(name, def, parse)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return ret
+}
+
+func GetInt(name string, def int) int {
+	return getVar(name, def, parseInt)
+}
+
+func GetIntErr(name string, def int) (int, error) {
+	return parseVar(name, def, parseInt)
+}
+
+func MustGetInt(name string, def int) int {
+	return mustGetVar(name, def, parseInt)
+}
+
+func GetUint32Err(name string, def uint32) (uint32, error) {
+	return parseVar(name, def, parseUint32)
+}
+
+func MustGetUint32(name string, def uint32) uint32 {
+	return mustGetVar(name, def, parseUint32)
+}
+
+func GetFloat(name string, def float64) float64 {
+	return getVar(name, def, parseFloat)
+}
+
+func GetFloatErr(name string, def float64) (float64, error) {
+	return parseVar(name, def, parseFloat)
+}
+
+func MustGetFloat(name string, def float64) float64 {
+	return mustGetVar(name, def, parseFloat)
+}
+
+func GetBool(name string, def bool) bool {
+	return getVar(name, def, ParseBool)
+}
+
+func GetBoolErr(name string, def bool) (bool, error) {
+	return parseVar(name, def, ParseBool)
+}
+
+func MustGetBool(name string, def bool) bool {
+	return mustGetVar(name, def, ParseBool)
+}
+
+// GetDuration reads a Go or ISO-8601 duration, see ParseDuration
+func GetDuration(name string, def time.Duration) time.Duration {
+	return getVar(name, def, ParseDuration)
+}
+
+func GetDurationErr(name string, def time.Duration) (time.Duration, error) {
+	return parseVar(name, def, ParseDuration)
+}
+
+func MustGetDuration(name string, def time.Duration) time.Duration {
+	return mustGetVar(name, def, ParseDuration)
+}
+
+// GetSize reads a size in bytes, see ParseSize
+func GetSize(name string, def int64) int64 {
+	return getVar(name, def, ParseSize)
+}
+
+func GetSizeErr(name string, def int64) (int64, error) {
+	return parseVar(name, def, ParseSize)
+}
+
+func MustGetSize(name string, def int64) int64 {
+	return mustGetVar(name, def, ParseSize)
+}
+
+// GetPercent reads a percentage as a fraction, see ParsePercent
+func GetPercent(name string, def float64) float64 {
+	return getVar(name, def, ParsePercent)
+}
+
+func GetPercentErr(name string, def float64) (float64, error) {
+	return parseVar(name, def, ParsePercent)
+}
+
+func MustGetPercent(name string, def float64) float64 {
+	return mustGetVar(name, def, ParsePercent)
+}
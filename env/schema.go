@@ -0,0 +1,448 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type VarType int
+
+// Values are validated with the same parsing as the getters of the
+// type, e.g. GetInt for Int and GetUint32 for Uint
+const (
+	String VarType = iota
+	Int
+	Uint
+	Float
+	Bool
+	Duration
+	Size
+	Percent
+)
+
+type (
+	// Var declares a variable the service reads so that it can be
+	// validated at startup and documented
+	Var struct {
+		pattern     *regexp.Regexp
+		min         *float64
+		max         *float64
+		Name        string
+		Default     string
+		Description string
+		Enum        []string
+		Type        VarType
+		Required    bool
+		Secret      bool
+	}
+
+	// Schema is a registry of declared variables
+	Schema struct {
+		vars []*Var
+		mu   sync.Mutex
+	}
+
+	// ValidationError describes why a variable is invalid
+	ValidationError struct {
+		Name   string
+		Value  string
+		Reason string
+	}
+)
+
+// The schema used by the package level functions
+var DefaultSchema = NewSchema()
+
+func (t VarType) String() string {
+	switch t {
+	case Int:
+		return "int"
+	case Uint:
+		return "uint"
+	case Float:
+		return "float"
+	case Bool:
+		return "bool"
+	case Duration:
+		return "duration"
+	case Size:
+		return "size"
+	case Percent:
+		return "percent"
+	default:
+		return "string"
+	}
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s=%q: %s", e.Name, e.Value, e.Reason)
+}
+
+func NewSchema() *Schema {
+	return &Schema{}
+}
+
+// Declare adds a variable to the default schema
+func Declare(name string, t VarType) *Var {
+	return DefaultSchema.Declare(name, t)
+}
+
+// Validate checks the variables in the default schema
+func Validate() error {
+	return DefaultSchema.Validate()
+}
+
+// Declare adds a variable to the schema, replacing any previous
+// declaration of the same name. Use the With methods on the
+// returned variable to add a default, description and rules.
+func (s *Schema) Declare(name string, t VarType) *Var {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := &Var{Name: name, Type: t}
+
+	i := slices.IndexFunc(s.vars, func(x *Var) bool { return x.Name == name })
+
+	if i == -1 {
+		s.vars = append(s.vars, v)
+	} else {
+		s.vars[i] = v
+	}
+
+	return v
+}
+
+// Vars returns the declared variables sorted by name
+func (s *Schema) Vars() []*Var {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := slices.Clone(s.vars)
+
+	slices.SortFunc(ret, func(a, b *Var) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return ret
+}
+
+// Validate checks every declared variable against its rules and
+// returns all the problems found joined into one error
+func (s *Schema) Validate() error {
+	var errs []error
+
+	for _, v := range s.Vars() {
+		if err := v.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WriteTable writes a plain text table of the variables, their
+// current values and their rules. Secret values are masked.
+func (s *Schema) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "NAME\tTYPE\tVALUE\tDEFAULT\tRULES\tDESCRIPTION")
+
+	for _, v := range s.Vars() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, v.Type, v.display(v.Get()), v.display(v.Default), v.Rules(), v.Description)
+	}
+
+	return tw.Flush()
+}
+
+// WriteMarkdown writes a markdown table of the variables
+// suitable for a README
+func (s *Schema) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("| Name | Type | Default | Required | Rules | Description |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+
+	for _, v := range s.Vars() {
+		def := ""
+
+		if v.Default != "" {
+			def = "`" + v.display(v.Default) + "`"
+		}
+
+		required := ""
+
+		if v.Required {
+			required = "yes"
+		}
+
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s |\n",
+			v.Name,
+			v.Type,
+			def,
+			required,
+			markdownEscape(v.Rules()),
+			markdownEscape(v.Description))
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// WriteSample writes a sample .env file with each variable set
+// to its default and documented in a comment above it
+func (s *Schema) WriteSample(w io.Writer) error {
+	var b strings.Builder
+
+	for i, v := range s.Vars() {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		if v.Description != "" {
+			fmt.Fprintf(&b, "# %s\n", v.Description)
+		}
+
+		rules := v.Type.String()
+
+		if r := v.Rules(); r != "" {
+			rules += ", " + r
+		}
+
+		if v.Required {
+			rules += ", required"
+		}
+
+		fmt.Fprintf(&b, "# %s\n", rules)
+
+		if v.Secret {
+			fmt.Fprintf(&b, "# or %s%s=/run/secrets/%s\n", v.Name, FileSuffix, strings.ToLower(v.Name))
+			fmt.Fprintf(&b, "%s=\n", v.Name)
+		} else {
+			fmt.Fprintf(&b, "%s=%s\n", v.Name, v.Default)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func (v *Var) WithDefault(def string) *Var {
+	v.Default = def
+	return v
+}
+
+func (v *Var) WithDescription(description string) *Var {
+	v.Description = description
+	return v
+}
+
+// WithRange limits numeric variables to [min, max]. Durations are
+// compared in seconds, sizes in bytes and percentages as fractions.
+// It panics if the variable is not numeric since schemas are
+// declared by the programmer.
+func (v *Var) WithRange(min float64, max float64) *Var {
+	v.checkNumeric()
+	v.min = &min
+	v.max = &max
+	return v
+}
+
+// WithMin sets only a lower bound on a numeric variable
+func (v *Var) WithMin(min float64) *Var {
+	v.checkNumeric()
+	v.min = &min
+	return v
+}
+
+// WithMax sets only an upper bound on a numeric variable
+func (v *Var) WithMax(max float64) *Var {
+	v.checkNumeric()
+	v.max = &max
+	return v
+}
+
+func (v *Var) checkNumeric() {
+	if v.Type == String || v.Type == Bool {
+		panic(fmt.Sprintf("env: %s is a %s so cannot have a range", v.Name, v.Type))
+	}
+}
+
+// WithPattern requires the value to match a regular expression.
+// It panics if the pattern does not compile since schemas are
+// declared by the programmer.
+func (v *Var) WithPattern(pattern string) *Var {
+	v.pattern = regexp.MustCompile(pattern)
+	return v
+}
+
+// WithEnum limits the value to one of a list of choices
+func (v *Var) WithEnum(values ...string) *Var {
+	v.Enum = values
+	return v
+}
+
+// WithRequired means the variable must be set if it has no default
+func (v *Var) WithRequired() *Var {
+	v.Required = true
+	return v
+}
+
+// WithSecret masks the value in outputs
+func (v *Var) WithSecret() *Var {
+	v.Secret = true
+	return v
+}
+
+// Get returns the value of the variable or its default
+func (v *Var) Get() string {
+	return GetStr(v.Name, v.Default)
+}
+
+// Validate checks the current value against the rules of the variable
+func (v *Var) Validate() error {
+	value := v.Get()
+
+	if value == "" {
+		if v.Required {
+			return v.invalid(value, "required")
+		}
+
+		return nil
+	}
+
+	var n float64
+
+	switch v.Type {
+	case Int:
+		i, err := parseInt(value)
+
+		if err != nil {
+			return v.invalid(value, "not an int")
+		}
+
+		n = float64(i)
+	case Uint:
+		u, err := parseUint32(value)
+
+		if err != nil {
+			return v.invalid(value, "not a uint")
+		}
+
+		n = float64(u)
+	case Float:
+		f, err := parseFloat(value)
+
+		if err != nil {
+			return v.invalid(value, "not a float")
+		}
+
+		n = f
+	case Bool:
+		_, err := ParseBool(value)
+
+		if err != nil {
+			return v.invalid(value, "not a bool")
+		}
+	case Duration:
+		d, err := ParseDuration(value)
+
+		if err != nil {
+			return v.invalid(value, "not a duration")
+		}
+
+		n = d.Seconds()
+	case Size:
+		size, err := ParseSize(value)
+
+		if err != nil {
+			return v.invalid(value, "not a size")
+		}
+
+		n = float64(size)
+	case Percent:
+		p, err := ParsePercent(value)
+
+		if err != nil {
+			return v.invalid(value, "not a percentage")
+		}
+
+		n = p
+	}
+
+	if v.min != nil && n < *v.min {
+		return v.invalid(value, fmt.Sprintf("less than %v", v.bound(*v.min)))
+	}
+
+	if v.max != nil && n > *v.max {
+		return v.invalid(value, fmt.Sprintf("greater than %v", v.bound(*v.max)))
+	}
+
+	if v.pattern != nil && !v.pattern.MatchString(value) {
+		return v.invalid(value, fmt.Sprintf("does not match %s", v.pattern))
+	}
+
+	if len(v.Enum) > 0 && !slices.Contains(v.Enum, value) {
+		return v.invalid(value, fmt.Sprintf("not one of %s", strings.Join(v.Enum, ", ")))
+	}
+
+	return nil
+}
+
+// Rules returns a short human readable summary of the rules
+func (v *Var) Rules() string {
+	rules := make([]string, 0, 3)
+
+	switch {
+	case v.min != nil && v.max != nil:
+		rules = append(rules, fmt.Sprintf("%v..%v", v.bound(*v.min), v.bound(*v.max)))
+	case v.min != nil:
+		rules = append(rules, fmt.Sprintf(">= %v", v.bound(*v.min)))
+	case v.max != nil:
+		rules = append(rules, fmt.Sprintf("<= %v", v.bound(*v.max)))
+	}
+
+	if v.pattern != nil {
+		rules = append(rules, "matches "+v.pattern.String())
+	}
+
+	if len(v.Enum) > 0 {
+		rules = append(rules, "one of "+strings.Join(v.Enum, "|"))
+	}
+
+	return strings.Join(rules, ", ")
+}
+
+// Formats a bound in the units of the variable, e.g. 90
+// seconds is 1m30s for a duration
+func (v *Var) bound(n float64) any {
+	if v.Type == Duration {
+		return time.Duration(n * float64(time.Second))
+	}
+
+	return n
+}
+
+func (v *Var) invalid(value string, reason string) error {
+	return &ValidationError{Name: v.Name, Value: v.display(value), Reason: reason}
+}
+
+func (v *Var) display(value string) string {
+	if v.Secret && value != "" {
+		return "********"
+	}
+
+	return value
+}
+
+func markdownEscape(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
@@ -0,0 +1,175 @@
+package env
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/antonybholmes/go-sys/log"
+)
+
+// Suffix of variables that point to a file holding the
+// actual value, e.g. DB_PASSWORD_FILE=/run/secrets/db
+const FileSuffix = "_FILE"
+
+// Default mount point for Docker and Kubernetes secrets
+const DefaultSecretsDir = "/run/secrets"
+
+type (
+	// SecretProvider resolves variables that are not set
+	// directly in the environment, e.g. from files or a vault.
+	// It should return false if it does not know the name.
+	SecretProvider interface {
+		Secret(name string) (string, bool, error)
+	}
+
+	// SecretProviderFunc adapts a plain function to a SecretProvider
+	SecretProviderFunc func(name string) (string, bool, error)
+
+	// FileSecrets implements the NAME_FILE convention where the
+	// value of NAME is read from the file NAME_FILE points to
+	FileSecrets struct{}
+
+	// DirSecrets reads NAME from a file of the same name in a
+	// directory, falling back to the lowercase name since that
+	// is how secrets are usually mounted, e.g.
+	// DB_PASSWORD from /run/secrets/db_password
+	DirSecrets struct {
+		Dir string
+	}
+
+	cachedSecret struct {
+		value string
+		found bool
+	}
+
+	secretStore struct {
+		mu        sync.RWMutex
+		providers []SecretProvider
+		cache     map[string]cachedSecret
+	}
+)
+
+var secrets = secretStore{
+	providers: []SecretProvider{FileSecrets{}},
+	cache:     make(map[string]cachedSecret),
+}
+
+func (f SecretProviderFunc) Secret(name string) (string, bool, error) {
+	return f(name)
+}
+
+func (FileSecrets) Secret(name string) (string, bool, error) {
+	file := os.Getenv(name + FileSuffix)
+
+	if file == "" {
+		return "", false, nil
+	}
+
+	value, err := readSecretFile(file)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+func NewDirSecrets(dir string) *DirSecrets {
+	return &DirSecrets{Dir: dir}
+}
+
+func (d *DirSecrets) Secret(name string) (string, bool, error) {
+	for _, file := range []string{name, strings.ToLower(name)} {
+		value, err := readSecretFile(filepath.Join(d.Dir, file))
+
+		if err == nil {
+			return value, true, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", false, err
+		}
+	}
+
+	return "", false, nil
+}
+
+// AddSecretProvider appends a provider to the chain. Providers
+// are asked in order and the first to find the name wins.
+func AddSecretProvider(provider SecretProvider) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+
+	secrets.providers = append(secrets.providers, provider)
+	clear(secrets.cache)
+}
+
+// SetSecretProviders replaces the provider chain. By default
+// only FileSecrets is used.
+func SetSecretProviders(providers ...SecretProvider) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+
+	secrets.providers = providers
+	clear(secrets.cache)
+}
+
+// ReloadSecrets clears cached secrets so they are read
+// again from their providers, e.g. after a rotation
+func ReloadSecrets() {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+
+	clear(secrets.cache)
+}
+
+// Looks up a secret, asking the providers only the first time
+// a name is seen. Misses are cached too so that plain variables
+// that are not set do not cost a file system call every time.
+func (s *secretStore) lookup(name string) (string, bool) {
+	s.mu.RLock()
+	c, ok := s.cache[name]
+	s.mu.RUnlock()
+
+	if ok {
+		return c.value, c.found
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, provider := range s.providers {
+		value, found, err := provider.Secret(name)
+
+		if err != nil {
+			// do not cache errors so we try again next time
+			log.Warn().Msgf("error reading secret %s: %v", name, err)
+			return "", false
+		}
+
+		if found {
+			s.cache[name] = cachedSecret{value: value, found: true}
+			return value, true
+		}
+	}
+
+	s.cache[name] = cachedSecret{}
+
+	return "", false
+}
+
+// Secret files usually end with a newline that is not
+// part of the value
+func readSecretFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
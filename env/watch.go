@@ -0,0 +1,335 @@
+package env
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antonybholmes/go-sys/log"
+	"github.com/rs/zerolog"
+)
+
+// Variable watched for log level changes, e.g. LOG_LEVEL=debug
+const LogLevelVar = "LOG_LEVEL"
+
+const DefaultWatchInterval = 10 * time.Second
+
+type (
+	ChangeKind int
+
+	// Change describes how the effective value of a variable
+	// changed after the watched files were reloaded
+	Change struct {
+		Name string
+		Old  string
+		New  string
+		Kind ChangeKind
+	}
+
+	ChangeFunc func(changes []Change)
+
+	// Watcher polls the files of a loader and re-applies them when
+	// they change. Polling keeps us free of platform specific file
+	// notification dependencies and also works for mounted volumes
+	// where such notifications are unreliable.
+	Watcher struct {
+		loader    *Loader
+		interval  time.Duration
+		stats     map[string]fileStat
+		keys      []string
+		callbacks []ChangeFunc
+		channels  []chan []Change
+		stop      chan struct{}
+		mu        sync.Mutex
+		stopOnce  sync.Once
+	}
+
+	fileStat struct {
+		modTime time.Time
+		size    int64
+		exists  bool
+	}
+)
+
+const (
+	Added ChangeKind = iota
+	Updated
+	Removed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "updated"
+	}
+}
+
+// NewWatcher creates a watcher for the files of a loader. Log level
+// changes are wired in by default so that setting LOG_LEVEL in a
+// watched file changes the level of the running service.
+func NewWatcher(loader *Loader, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	w := &Watcher{
+		loader:   loader,
+		interval: interval,
+		stats:    make(map[string]fileStat),
+		stop:     make(chan struct{}),
+	}
+
+	w.OnChange(logLevelChanged)
+
+	return w
+}
+
+// OnChange registers a callback that is called with the changes
+// each time the watched files are reloaded
+func (w *Watcher) OnChange(f ChangeFunc) *Watcher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.callbacks = append(w.callbacks, f)
+
+	return w
+}
+
+// Subscribe returns a channel that receives the changes each time
+// the watched files are reloaded. Sends never block, so if a reader
+// falls behind by more than buffer reloads, changes are dropped.
+// The channel is closed when the watcher is stopped.
+func (w *Watcher) Subscribe(buffer int) <-chan []Change {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c := make(chan []Change, max(buffer, 1))
+	w.channels = append(w.channels, c)
+
+	return c
+}
+
+// Start loads the files and polls them in the background until
+// Stop is called
+func (w *Watcher) Start() error {
+	_, err := w.reload()
+
+	if err != nil {
+		return err
+	}
+
+	go w.run()
+
+	return nil
+}
+
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		for _, c := range w.channels {
+			close(c)
+		}
+
+		w.channels = nil
+	})
+}
+
+// Check polls the files once and reloads them if any were
+// created, modified or deleted since the last check. Subscribers
+// are notified if this changed any variables.
+func (w *Watcher) Check() ([]Change, error) {
+	w.mu.Lock()
+	changed := !maps.Equal(w.stats, statFiles(w.loader.Layers()))
+	w.mu.Unlock()
+
+	if !changed {
+		return nil, nil
+	}
+
+	return w.reload()
+}
+
+func (w *Watcher) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			_, err := w.Check()
+
+			if err != nil {
+				log.Warn().Msgf("error reloading env files: %v", err)
+			}
+		}
+	}
+}
+
+func (w *Watcher) reload() ([]Change, error) {
+	w.mu.Lock()
+
+	layers := w.loader.Layers()
+	stats := statFiles(layers)
+
+	values, err := readLayers(layers)
+
+	if err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	names := slices.Clone(w.keys)
+
+	for k := range values {
+		if !slices.Contains(names, k) {
+			names = append(names, k)
+		}
+	}
+
+	before := lookupAll(names)
+
+	// variables no longer in any file go back to their
+	// real value or are unset
+	for _, k := range w.keys {
+		if _, ok := values[k]; !ok {
+			state.remove(k)
+		}
+	}
+
+	state.apply(values, w.loader.precedence)
+	ReloadSecrets()
+
+	after := lookupAll(names)
+
+	w.stats = stats
+	w.keys = slices.Sorted(maps.Keys(values))
+
+	changes := diff(names, before, after)
+
+	callbacks := slices.Clone(w.callbacks)
+
+	if len(changes) > 0 {
+		for _, c := range w.channels {
+			select {
+			case c <- changes:
+			default:
+				log.Warn().Msgf("env change subscriber is not keeping up, dropping changes")
+			}
+		}
+	}
+
+	w.mu.Unlock()
+
+	// call outside the lock so callbacks can use the watcher
+	if len(changes) > 0 {
+		for _, f := range callbacks {
+			f(changes)
+		}
+	}
+
+	return changes, nil
+}
+
+func (s *envState) remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.managed[name] {
+		return
+	}
+
+	if v, ok := s.process[name]; ok {
+		os.Setenv(name, v)
+	} else {
+		os.Unsetenv(name)
+	}
+
+	delete(s.managed, name)
+}
+
+func statFiles(files []string) map[string]fileStat {
+	ret := make(map[string]fileStat, len(files))
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+
+		if err != nil {
+			ret[file] = fileStat{}
+			continue
+		}
+
+		ret[file] = fileStat{modTime: info.ModTime(), size: info.Size(), exists: true}
+	}
+
+	return ret
+}
+
+func lookupAll(names []string) map[string]string {
+	ret := make(map[string]string, len(names))
+
+	for _, name := range names {
+		if v, ok := os.LookupEnv(name); ok {
+			ret[name] = v
+		}
+	}
+
+	return ret
+}
+
+// Returns the changes between two sets of values sorted
+// by name so that notifications are deterministic
+func diff(names []string, before map[string]string, after map[string]string) []Change {
+	ret := make([]Change, 0, len(names))
+
+	for _, name := range names {
+		old, hadOld := before[name]
+		v, hasNew := after[name]
+
+		switch {
+		case !hadOld && hasNew:
+			ret = append(ret, Change{Name: name, New: v, Kind: Added})
+		case hadOld && !hasNew:
+			ret = append(ret, Change{Name: name, Old: old, Kind: Removed})
+		case hadOld && hasNew && old != v:
+			ret = append(ret, Change{Name: name, Old: old, New: v, Kind: Updated})
+		}
+	}
+
+	slices.SortFunc(ret, func(a, b Change) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return ret
+}
+
+// Sets the global log level when LOG_LEVEL changes
+func logLevelChanged(changes []Change) {
+	for _, c := range changes {
+		if c.Name != LogLevelVar || c.New == "" {
+			continue
+		}
+
+		level, err := zerolog.ParseLevel(strings.ToLower(c.New))
+
+		if err != nil {
+			log.Warn().Msgf("invalid %s %q", LogLevelVar, c.New)
+			continue
+		}
+
+		log.SetLogLevel(level)
+		log.Info().Msgf("log level changed to %s", level)
+	}
+}
@@ -6,25 +6,35 @@ import (
 	"fmt"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/antonybholmes/go-sys"
 	"github.com/antonybholmes/go-sys/log"
 )
 
 type (
+	// SqlClauseFunc creates the sql for a search term whose value is
+	// a LIKE pattern, e.g. col LIKE :p1. Literal % and _ in terms are
+	// not escaped, so they still match any chars, unless the option
+	// WithLikeEscape is given, in which case they are escaped with a
+	// backslash and clauses must use e.g. col LIKE :p1 ESCAPE '\'
 	SqlClauseFunc func(placeholderIndex int, value string, addParens bool) string
 
+	// SqlFieldClauseFunc is like SqlClauseFunc but also receives the
+	// field of the search term, which is empty for plain terms so
+	// that the caller can search its default columns
+	SqlFieldClauseFunc func(placeholderIndex int, field string, value string, addParens bool) string
+
 	// Node represents an expression tree node
 	Node interface {
 		// Builds the sql representation of this node
-		// using the given clause function to create
+		// using the given builder to create
 		// the sql for each search term
-		// Param clause function to create sql clause for
-		// each search term here it becomes user and database
+		// Param b holds the clause functions to create sql
+		// clauses for each search term here it becomes user and database
 		// specific so user supplies function to supply the
-		// actual sql clause for each term
-		// Param args is a pointer to a slice of strings
-		// that we append the actual search term values to
+		// actual sql clause for each term. The builder also
+		// collects the actual search term values
 		// as we build the sql so that the caller can use
 		// them as query parameters.
 		// Param addParens indicates whether to add parentheses
@@ -33,12 +43,28 @@ type (
 		// does not need parens around its child as it is self contained.
 		// We delegate whether to add parens to the sub expression since
 		// simple sql statements may not require them.
-		BuildSql(clause SqlClauseFunc, addParens bool, args *[]string) string
+		BuildSql(b *SqlBuilder, addParens bool) string
+
+		// Evaluates the expression against an in-memory record
+		// whose values are supplied by the matcher, using the
+		// same matching rules as the generated sql
+		Eval(match Matcher) bool
+
+		// Renders the node in the query syntax such that
+		// parsing it gives back the same tree
+		String() string
 	}
 
+	MatchType int
+
 	// SearchTermNode for variables like A, B, etc.
+	// Value is the term as the user typed it with * and ?
+	// wildcards, which are converted to sql LIKE patterns
+	// by the dialect. Field is set for field:value terms.
 	SearchTermNode struct {
-		Term string
+		Value     string
+		Field     string
+		MatchType MatchType
 	}
 
 	NotNode struct {
@@ -59,24 +85,62 @@ type (
 
 	// Parser struct
 	Parser struct {
+		opts  *options
 		input string
 		pos   int
+
+		// the query as the user typed it and, for each byte of
+		// input, its offset in source so that errors can point
+		// at what the user typed
+		source  string
+		offsets []int
+
+		// what has been parsed so far, to enforce the limits
+		depth            int
+		terms            int
+		leadingWildcards int
 	}
 )
 
-// Replace boolean words AND/OR with +/, but only when they are separate words
+const (
+	// match anywhere in the value, the default
+	MatchTypeContains MatchType = iota
+	// match the whole value e.g. =BCL6, ^BCL6$ or ="B cell"
+	MatchTypeExact
+	// the user supplied * wildcards so the match is anchored
+	// at both ends and only the wildcards match anything
+	MatchTypePattern
+)
+
+// Replace boolean words AND/OR/NOT with +/,/! but only when they are separate words
 // and not with quotes
 func normalizeBooleanWords(input string) string {
-	var b strings.Builder
+	ret, _ := normalizeBooleanWordsOffsets(input, false)
+	return ret
+}
+
+// Like normalizeBooleanWords but also returns the offset in the
+// input of each byte of the output. If foldCase is true, the
+// boolean words can be in any case, e.g. and.
+func normalizeBooleanWordsOffsets(input string, foldCase bool) (string, []int) {
+	var b offsetBuilder
 
 	inQuotes := false
 
 	for i := 0; i < len(input); {
 		ch := input[i]
 
+		// escaped chars are copied as is so \" does not start
+		// a quote and \AND is not a boolean word
+		if ch == EscapeChar && i+1 < len(input) {
+			b.writeString(input[i:i+2], i)
+			i += 2
+			continue
+		}
+
 		if ch == '"' {
 			inQuotes = !inQuotes
-			b.WriteByte(ch)
+			b.writeByte(ch, i)
 			i++
 			continue
 		}
@@ -91,54 +155,92 @@ func normalizeBooleanWords(input string) string {
 				i++
 			}
 
-			// since a run must start with a letter, then
-			// came before (and after) must be non-letter e.g.
-			// space, therefore if we see AND and OR they
-			// must be surrounded by non-letter chars and can
-			// be treated as boolean operators
+			// the words must be on their own, e.g. separated by
+			// spaces or parens, so that words in terms such as
+			// the genes OR4F5 or BCL6-AND are left alone
 			word := input[start:i]
-			switch word {
-			case "AND":
-				b.WriteByte('+')
-			case "OR":
-				b.WriteByte(',')
+
+			if foldCase {
+				word = strings.ToUpper(word)
+			}
+
+			before, _ := utf8.DecodeLastRuneInString(input[:start])
+			after, _ := utf8.DecodeRuneInString(input[i:])
+
+			isWord := (start == 0 || !isWordChar(before)) &&
+				(i == len(input) || !isWordChar(after))
+
+			switch {
+			case isWord && word == "AND":
+				b.writeByte('+', start)
+			case isWord && word == "OR":
+				b.writeByte(',', start)
+			case isWord && word == "NOT":
+				b.writeByte('!', start)
 			default:
 				// not a boolean word, write as is
-				b.WriteString(word)
+				b.writeString(input[start:i], start)
 			}
 			continue
 		}
 
 		// not a letter, write as is
-		b.WriteByte(ch)
+		b.writeByte(ch, i)
 		i++
 	}
 
-	return b.String()
+	return b.String(), b.offsets
 }
 
 // Deals with implicit ANDs represented by spaces between words
 // and also normalizes boolean words like AND/OR to +/,
 func normalizeQuery(input string) string {
-	var b strings.Builder
+	ret, _ := normalizeQueryOffsets(input, false)
+	return ret
+}
+
+// Like normalizeQuery but also returns the offset in the input of
+// each byte of the output, plus one for the end of the output, so
+// that errors can point at what the user actually typed
+func normalizeQueryOffsets(input string, foldCase bool) (string, []int) {
+	var b offsetBuilder
 	inQuotes := false
 	last := rune(0)
 	isRunOfSpaces := false
+	escaped := false
 
 	// replace of AND/OR words first
-	input = normalizeBooleanWords(input)
+	words, wordOffsets := normalizeBooleanWordsOffsets(input, foldCase)
+
+	for i, ch := range words {
+		offset := wordOffsets[i]
+
+		// the char after a \ is part of the term whatever it is
+		if escaped {
+			b.writeRune(ch, offset)
+			escaped = false
+			continue
+		}
 
-	for _, ch := range input {
 		switch ch {
 		case '"':
+			// an opening quote after a run of spaces starts
+			// a new search term so it is an implicit AND
+			if !inQuotes &&
+				isRunOfSpaces &&
+				isSearchTermChar(last) &&
+				last != '(' {
+				b.writeRune('+', offset)
+			}
+
 			inQuotes = !inQuotes
-			b.WriteRune(ch)
+			b.writeRune(ch, offset)
 			last = ch
 			isRunOfSpaces = false
 
 		case ' ':
 			if inQuotes {
-				b.WriteRune(ch)
+				b.writeRune(ch, offset)
 				isRunOfSpaces = false
 				continue
 			}
@@ -154,7 +256,17 @@ func normalizeQuery(input string) string {
 			}
 
 		case '+', ',', '(', ')':
-			b.WriteRune(ch)
+			// a sub-expression after a run of spaces is
+			// also an implicit AND e.g. A (B, C)
+			if ch == '(' &&
+				!inQuotes &&
+				isRunOfSpaces &&
+				isSearchTermChar(last) &&
+				last != '(' {
+				b.writeRune('+', offset)
+			}
+
+			b.writeRune(ch, offset)
 
 			// if we are in a run of spaces
 			// cancel the run since are either
@@ -163,25 +275,43 @@ func normalizeQuery(input string) string {
 				last = ch
 				isRunOfSpaces = false
 			}
-		case '*':
-			if !inQuotes {
-				// convert * to % for SQL wildcard
-				ch = '%'
+
+		case '!':
+			// a NOT starts a new term so after a run of
+			// spaces it is an implicit AND, e.g. A !B, but
+			// spaces after it are not since it is an operator
+			if !inQuotes &&
+				isRunOfSpaces &&
+				isSearchTermChar(last) &&
+				last != '(' {
+				b.writeRune('+', offset)
 			}
 
-			b.WriteRune(ch)
-			last = ch
-			isRunOfSpaces = false
-		case '?':
+			b.writeRune(ch, offset)
+
 			if !inQuotes {
-				// convert ? to _ for SQL single char wildcard
-				ch = '_'
+				last = ch
+				isRunOfSpaces = false
 			}
 
-			b.WriteRune(ch)
-			last = ch
-			isRunOfSpaces = false
+		case '<', '>':
+			// comparison operators join the field and value
+			// so spaces around them are not implicit ANDs
+			b.writeRune(ch, offset)
+
+			if !inQuotes {
+				last = ch
+				isRunOfSpaces = false
+			}
+		case '=':
+			// = is part of the >= and <= operators, otherwise
+			// it is a normal word char for exact matches
+			if !inQuotes && (last == '<' || last == '>') {
+				b.writeRune(ch, offset)
+				continue
+			}
 
+			fallthrough
 		default:
 			// the last was a word char and we had a
 			// run of spaces, so insert a + as implicit AND
@@ -190,21 +320,60 @@ func normalizeQuery(input string) string {
 				isSearchTermChar(last) &&
 				last != '(' &&
 				isSearchTermChar(ch) {
-				b.WriteRune('+')
+				b.writeRune('+', offset)
 			}
 
-			b.WriteRune(ch)
+			b.writeRune(ch, offset)
 			last = ch
 			isRunOfSpaces = false
+			escaped = ch == EscapeChar
 		}
 	}
 
-	return b.String()
+	// so the end of the output maps to the end of the input
+	b.offsets = append(b.offsets, len(input))
+
+	return b.String(), b.offsets
+}
+
+// offsetBuilder is a strings.Builder that records the offset in
+// some source string of each byte written
+type offsetBuilder struct {
+	b       strings.Builder
+	offsets []int
+}
+
+func (b *offsetBuilder) String() string {
+	return b.b.String()
+}
+
+func (b *offsetBuilder) writeByte(c byte, offset int) {
+	b.b.WriteByte(c)
+	b.offsets = append(b.offsets, offset)
+}
+
+// Each byte of the rune maps to the same offset
+func (b *offsetBuilder) writeRune(r rune, offset int) {
+	n, _ := b.b.WriteRune(r)
+
+	for range n {
+		b.offsets = append(b.offsets, offset)
+	}
+}
+
+// The string is copied from the source at offset
+func (b *offsetBuilder) writeString(s string, offset int) {
+	b.b.WriteString(s)
+
+	for i := range len(s) {
+		b.offsets = append(b.offsets, offset+i)
+	}
 }
 
 // represents a search token that is not part of a boolean expression
 func isWordChar(c rune) bool {
 	return unicode.IsLetter(c) ||
+		unicode.IsMark(c) ||
 		unicode.IsDigit(c) ||
 		c == '-' ||
 		c == '_' ||
@@ -215,11 +384,12 @@ func isWordChar(c rune) bool {
 		c == ':' ||
 		c == '%' ||
 		c == '*' ||
-		c == '?'
+		c == '?' ||
+		c == EscapeChar
 }
 
 func isSearchTermChar(c rune) bool {
-	return isWordChar(c) || c == '(' || c == ')'
+	return isWordChar(c) || c == '(' || c == ')' || c == '"'
 }
 
 // func peek(s string, i int) rune {
@@ -237,7 +407,7 @@ func newSearchTermNode(raw string, isExact bool, hasWildcards bool) (*SearchTerm
 		return nil, errors.New("empty search term")
 	}
 
-	ret := SearchTermNode{Term: raw} //, MatchType: MatchTypeContains}
+	ret := SearchTermNode{Value: raw, MatchType: MatchTypeContains}
 
 	// if strings.HasPrefix(raw, "-") {
 	// 	ret.Not = true
@@ -248,15 +418,20 @@ func newSearchTermNode(raw string, isExact bool, hasWildcards bool) (*SearchTerm
 	// 	}
 	// }
 
-	// Here wildcards refers only to %. If the user has specified
-	// single character wildcards with ?, we will still add % around
-	// the term unless it's an exact match
-	if !isExact && !hasWildcards {
+	// Here wildcards refers only to * (or %). If the user has specified
+	// single character wildcards with ?, we will still match anywhere
+	// in the value unless it's an exact match
+	switch {
+	case isExact:
+		ret.MatchType = MatchTypeExact
+	case hasWildcards:
+		ret.MatchType = MatchTypePattern
+	default:
 		// if not exact match and user has not specified wildcards,
-		// we default to contains by adding % around the term since
+		// we default to contains since
 		// this the most intuitive behavior to look for anything
 		// that contains the term we asked for
-		ret.Term = "%" + ret.Term + "%"
+		ret.MatchType = MatchTypeContains
 	}
 
 	// switch {
@@ -302,71 +477,64 @@ func newSearchTermNode(raw string, isExact bool, hasWildcards bool) (*SearchTerm
 	return &ret, nil
 }
 
-// func (v VarNode) Eval(vars map[string]bool) bool {
-// 	return vars[v.Value]
-// }
-
-func (v *SearchTermNode) BuildSql(clause SqlClauseFunc, addParens bool, args *[]string) string {
-	// switch v.MatchType {
-	// case MatchTypeExact:
-	// 	*args = append(*args, v.Value)
-	// case MatchTypeStartsWith:
-	// 	*args = append(*args, v.Value+"%")
-	// case MatchTypeEndsWith:
-	// 	*args = append(*args, "%"+v.Value)
-	// default:
-	// 	*args = append(*args, "%"+v.Value+"%")
-	// }
-
-	*args = append(*args, v.Term)
-
-	//log.Debug().Msgf("args %v", args)
+func (v *SearchTermNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	return b.termClause(v.Field, b.dialect.LikePattern(v.Value, v.MatchType), addParens)
+}
 
-	// as we parse the tree in order, the placeholder index is just
-	// the current length of the args slice as we add to it
-	placeholderIndex := len(*args) //fmt.Sprintf("?%d", len(*args))
-	//tagClauses = append(tagClauses, fmt.Sprintf("(gex.gene_symbol LIKE %s OR gex.ensembl_id LIKE %s)", placeholder, placeholder))
-	return clause(placeholderIndex, v.Term, addParens)
+// highest precedence is to negate a term
+func (n *NotNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	return "NOT (" + n.Child.BuildSql(b, false) + ")"
 }
 
-// func (a AndNode) Eval(vars map[string]bool) bool {
-// 	return a.Left.Eval(vars) && a.Right.Eval(vars)
-// }
+// Chains such as A AND B AND C are left deep so the left operand
+// only needs parens if it is a different operation, which stops
+// long chains from nesting
+func (a *AndNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	_, flat := a.Left.(*AndNode)
 
-// highest precedence is to negate a term
-func (n *NotNode) BuildSql(clause SqlClauseFunc, addParens bool, args *[]string) string {
-	return "NOT (" + n.Child.BuildSql(clause, false, args) + ")"
+	return AddParens(a.Left.BuildSql(b, !flat)+" AND "+a.Right.BuildSql(b, true), addParens)
 }
 
-func (a *AndNode) BuildSql(clause SqlClauseFunc, addParens bool, args *[]string) string {
-	return AddParens(a.Left.BuildSql(clause, true, args)+" AND "+a.Right.BuildSql(clause, true, args), addParens)
+func (o *OrNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	_, flat := o.Left.(*OrNode)
+
+	return AddParens(o.Left.BuildSql(b, !flat)+" OR "+o.Right.BuildSql(b, true), addParens)
 }
 
-// func (o OrNode) Eval(vars map[string]bool) bool {
-// 	return o.Left.Eval(vars) || o.Right.Eval(vars)
-// }
+// Field adapts a clause function that does not care about
+// fields so that it can be used to build sql from any tree
+func (f SqlClauseFunc) Field() SqlFieldClauseFunc {
+	if f == nil {
+		return nil
+	}
 
-func (o *OrNode) BuildSql(clause SqlClauseFunc, addParens bool, args *[]string) string {
-	return AddParens(o.Left.BuildSql(clause, true, args)+" OR "+o.Right.BuildSql(clause, true, args), addParens)
+	return func(placeholderIndex int, field string, value string, addParens bool) string {
+		return f(placeholderIndex, value, addParens)
+	}
 }
 
-func NewParser(input string) *Parser {
-	return &Parser{input: input}
+func NewParser(input string, opts ...Option) *Parser {
+	return &Parser{input: input, source: input, opts: newOptions(opts)}
 }
 
+// Returns the char at the current position, decoding multi-byte
+// chars so that e.g. α is one letter rather than two bytes
 func (p *Parser) peek() rune {
 	if p.pos >= len(p.input) {
 		return 0
 	}
-	return rune(p.input[p.pos])
+
+	ch, _ := utf8.DecodeRuneInString(p.input[p.pos:])
+	return ch
 }
 
 func (p *Parser) next() rune {
 	if p.pos >= len(p.input) {
 		return 0
 	}
-	ch := rune(p.input[p.pos])
-	p.pos++
+
+	ch, size := utf8.DecodeRuneInString(p.input[p.pos:])
+	p.pos += size
 	return ch
 }
 
@@ -381,7 +549,25 @@ func (p *Parser) skipWhitespace() {
 // Entry point: parse expression with OR as lowest precedence
 // e.g. A + B, C would be (A AND B) OR C
 func (p *Parser) ParseExpr() (Node, error) {
-	return p.parseOrSubClause()
+	if err := p.checkLength(); err != nil {
+		return nil, err
+	}
+
+	expr, err := p.parseOrSubClause()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipWhitespace()
+
+	// anything left over, e.g. a stray ), means the query
+	// was not what it appeared to be so reject it
+	if p.pos < len(p.input) {
+		return nil, p.errorAt(p.pos, errors.New("unexpected input"), "+", ",", "end of input")
+	}
+
+	return expr, nil
 }
 
 // Handle ORs
@@ -417,10 +603,18 @@ func (p *Parser) parseOrSubClause() (Node, error) {
 func (p *Parser) parseNotSubClause() (Node, error) {
 	p.skipWhitespace()
 
-	// check for NOT operator
+	// check for NOT operator, which binds tighter than AND and
+	// can be repeated, e.g. --A is NOT(NOT(A)). Each NOT nests
+	// so counts towards the max depth like parens do.
 	if p.peek() == '-' || p.peek() == '!' {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+
+		defer p.leave()
+
 		p.next()
-		child, err := p.parseSearchTerm()
+		child, err := p.parseNotSubClause()
 
 		if err != nil {
 			return nil, err
@@ -445,7 +639,7 @@ func (p *Parser) parseAndSubClause() (Node, error) {
 		p.skipWhitespace()
 		if p.peek() == '+' {
 			p.next()
-			right, err := p.parseSearchTerm()
+			right, err := p.parseNotSubClause()
 
 			if err != nil {
 				return nil, err
@@ -494,6 +688,12 @@ func (p *Parser) parseSearchTerm() (Node, error) {
 	// if we see a '(', we have a sub-expression
 	// so we parse that recursively
 	if ch == '(' {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+
+		defer p.leave()
+
 		p.next()
 		expr, err := p.parseOrSubClause()
 
@@ -502,100 +702,231 @@ func (p *Parser) parseSearchTerm() (Node, error) {
 		}
 
 		if p.peek() != ')' {
-			return nil, errors.New("missing closing parenthesis")
+			return nil, p.errorAt(p.pos, errors.New("missing closing parenthesis"), ")")
 		}
 
 		p.next()
 		return expr, nil
 	}
 
-	// if we see a quote, we have a quoted variable so
-	// parse until the closing quote as is an keep all chars
-	if ch == '"' {
-		p.next()
-		start := p.pos
-		for {
-			// we want to keep all chars until we see the closing quote
-			// or the end of the input
-			if p.peek() == '"' || p.peek() == 0 {
-				break
-			}
-			p.next()
-		}
+	start := p.pos
 
-		if p.peek() != '"' {
-			return nil, errors.New("unterminated quoted variable")
-		}
+	node, err := p.parseTerm()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.countTerm(node, start); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Parses a single term, comparison or range
+func (p *Parser) parseTerm() (Node, error) {
+	fieldStart := p.pos
+
+	field, err := p.parseField()
+
+	if err != nil {
+		return nil, p.errorAt(fieldStart, err)
+	}
+
+	// params in templates, e.g. $gene
+	node, ok, err := p.parseParam(field)
 
-		word := p.input[start:p.pos]
+	if ok || err != nil {
+		return node, err
+	}
+
+	ch := p.peek()
 
-		// consume closing quote
+	// ="..." is an exact match of the quoted value
+	if ch == '=' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '"' {
+		start := p.pos
 		p.next()
 
-		ret, err := newSearchTermNode(word, true, false)
+		value, err := p.parseQuoted()
 
 		if err != nil {
 			return nil, err
 		}
 
+		ret, err := newSearchTermNode(value, true, false)
+
+		if err != nil {
+			return nil, p.errorAt(start, err)
+		}
+
+		ret.Field = field
+
 		return ret, nil
 	}
 
+	// if we see a quote, we have a phrase, or with ~N after
+	// it, words that must be near each other
+	if ch == '"' {
+		return p.parsePhrase(field)
+	}
+
+	// numeric comparisons e.g. score>0.5
+	node, ok, err = p.parseComparison(field)
+
+	if err != nil {
+		return nil, p.errorAt(fieldStart, err)
+	}
+
+	if ok {
+		return node, nil
+	}
+
 	// Unquoted variable
 	start := p.pos
 
 	// keep advancing until we reach the end of the
 	// search term i.e. a space or other expression char
 	for isWordChar(p.peek()) {
-		p.next()
+		// escaped chars are always part of the term
+		if p.next() == EscapeChar && p.peek() != 0 {
+			p.next()
+		}
 	}
 
 	// if we did not advance, it's an error
 	if start == p.pos {
-		return nil, errors.New("expected variable")
+		return nil, p.errorAt(p.pos, errors.New("expected variable"), "search term", "(", `"`)
 	}
 
-	// extract the token without leading/trailing spaces
-	value := strings.TrimSpace(p.input[start:p.pos])
+	// extract the token, which cannot have leading/trailing
+	// spaces unless they were escaped, e.g. a\ is "a "
+	value := p.input[start:p.pos]
 
-	isExact := strings.HasPrefix(value, "=") || (strings.HasPrefix(value, "^") && strings.HasSuffix(value, "$"))
-	hasWildcards := strings.ContainsAny(value, "%")
-	hasSingleWildcards := strings.ContainsAny(value, "?")
+	// numeric ranges e.g. pos:1000..2000
+	node, ok, err = p.parseRange(field, value)
+
+	if err != nil {
+		return nil, p.errorAt(start, err)
+	}
+
+	if ok {
+		return node, nil
+	}
+
+	isExact := strings.HasPrefix(value, "=") || (strings.HasPrefix(value, "^") && endsWithUnescaped(value, '$'))
+
+	// only * and ? are wildcards, % and _ are literals, and
+	// users can escape wildcards with a backslash e.g. \*
+	hasWildcards := containsUnescaped(value, '*')
+	hasSingleWildcards := containsUnescaped(value, '?')
 
 	if isExact && (hasWildcards || hasSingleWildcards) {
-		return nil, errors.New("cannot have wildcards in exact match")
+		return nil, p.errorAt(start, errors.New("cannot have wildcards in exact match"))
 	}
 
 	if isExact {
 		// strip exact match markers
 		value = strings.TrimPrefix(value, "=")
 		value = strings.TrimPrefix(value, "^")
-		value = strings.TrimSuffix(value, "$")
+
+		if endsWithUnescaped(value, '$') {
+			value = value[:len(value)-1]
+		}
+
+		// exact values are literals
+		value = unescape(value)
+	} else {
+		value = canonicalGlob(value)
+	}
+
+	// a single trailing * is a prefix
+	if prefix, ok := prefixValue(value); ok && !hasSingleWildcards {
+		return &PrefixNode{Field: field, Value: prefix}, nil
 	}
 
 	// make it into a SearchNode which also determines the match type
 	ret, err := newSearchTermNode(value, isExact, hasWildcards)
 
 	if err != nil {
-		return nil, err
+		return nil, p.errorAt(start, err)
 	}
 
+	ret.Field = field
+
 	return ret, nil
 }
 
+// Parses the optional field: prefix of a search term. Fields
+// are only recognised when the parser has been given fields
+// and any other name before a : is an error.
+func (p *Parser) parseField() (string, error) {
+	if p.opts.fields == nil {
+		return "", nil
+	}
+
+	end := p.pos
+
+	for end < len(p.input) && isFieldChar(rune(p.input[end])) {
+		end++
+	}
+
+	if end == p.pos || end >= len(p.input) || p.input[end] != ':' {
+		return "", nil
+	}
+
+	name := p.input[p.pos:end]
+
+	field, ok := p.opts.fields.canonical(name)
+
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownField, name)
+	}
+
+	// skip the :
+	p.pos = end + 1
+
+	return field, nil
+}
+
+// SqlBoolQueryResp is the sql for a query and its args in the order
+// of their placeholders. Args are typed, strings for terms and int64
+// or float64 for numbers, so they can be given to any database/sql
+// driver.
 type SqlBoolQueryResp struct {
 	Sql  string
-	Args []string
+	Args []any
+}
+
+// Positional returns the args for dialects with positional
+// placeholders such as $1 or ?, e.g. db.Query(resp.Sql, resp.Positional()...)
+func (resp *SqlBoolQueryResp) Positional() []any {
+	return resp.Args
 }
 
-func SqlBoolTree(query string) (Node, error) {
+// Named returns the args as named args p1, p2, etc. for dialects
+// with named placeholders such as :p1
+func (resp *SqlBoolQueryResp) Named() []any {
+	return IndexedNamedArgs(resp.Args)
+}
+
+func SqlBoolTree(query string, opts ...Option) (Node, error) {
+	parser := NewParser(query, opts...)
+
+	// reject oversized queries before they are copied and logged
+	if err := parser.checkLength(); err != nil {
+		return nil, err
+	}
 
 	// first normalize query to replace spaces with + to be treated as ands
-	query = normalizeQuery(query)
+	normalized, offsets := normalizeQueryOffsets(query, parser.opts.foldKeywords)
 
-	log.Debug().Msgf("normalized query: %s", query)
+	log.Debug().Msgf("normalized query: %s", normalized)
 
-	parser := NewParser(query)
+	// parse the normalized query but errors should
+	// point at the query as typed
+	parser.input = normalized
+	parser.offsets = offsets
 
 	// create the expression tree
 	tree, err := parser.ParseExpr()
@@ -608,26 +939,24 @@ func SqlBoolTree(query string) (Node, error) {
 }
 
 func SqlBoolQueryFromTree(tree Node, clause SqlClauseFunc) (*SqlBoolQueryResp, error) {
-
-	// required so that we can use it with sqlite params
-	args := make([]string, 0, 20)
-
-	// build the sql from the tree
-	sql := tree.BuildSql(clause, false, &args)
-
-	return &SqlBoolQueryResp{Sql: sql, Args: args}, nil
-
+	return SqlFieldBoolQueryFromTree(tree, clause.Field())
 }
 
-func SqlBoolQuery(query string, clause SqlClauseFunc) (*SqlBoolQueryResp, error) {
-
-	// first normalize query to replace spaces with + to be treated as ands
-	query = normalizeQuery(query)
+// SqlFieldBoolQueryFromTree builds sql from a tree with field:value
+// terms. If fields are given, the clause receives the sql column of
+// each field rather than its name and unknown fields are an error.
+func SqlFieldBoolQueryFromTree(tree Node, clause SqlFieldClauseFunc, opts ...Option) (*SqlBoolQueryResp, error) {
+	return NewSqlBuilder(clause, opts...).Build(tree)
+}
 
-	parser := NewParser(query)
+func SqlBoolQuery(query string, clause SqlClauseFunc, opts ...Option) (*SqlBoolQueryResp, error) {
+	return SqlFieldBoolQuery(query, clause.Field(), opts...)
+}
 
-	// create the expression tree
-	tree, err := parser.ParseExpr()
+// SqlFieldBoolQuery is like SqlBoolQuery but the clause also
+// receives the field of each term, see SqlFieldBoolQueryFromTree
+func SqlFieldBoolQuery(query string, clause SqlFieldClauseFunc, opts ...Option) (*SqlBoolQueryResp, error) {
+	tree, err := SqlBoolTree(query, opts...)
 
 	if err != nil {
 		return nil, err
@@ -635,13 +964,7 @@ func SqlBoolQuery(query string, clause SqlClauseFunc) (*SqlBoolQueryResp, error)
 
 	// As we parse, we build up the args slice of search terms
 	// in order that they appear in the sql
-	args := make([]string, 0, 20)
-
-	// build the sql from the tree
-	sql := tree.BuildSql(clause, false, &args)
-
-	return &SqlBoolQueryResp{Sql: sql, Args: args}, nil
-
+	return SqlFieldBoolQueryFromTree(tree, clause, opts...)
 }
 
 // Adds parentheses around the sql if addParens is true
@@ -663,7 +986,7 @@ func IndexedParam(index int) string {
 }
 
 // Defines named args for sql queries with indexed parameters
-func IndexedNamedArgs(args []string) []any {
+func IndexedNamedArgs(args []any) []any {
 	ret := make([]any, 0, len(args))
 
 	for i, arg := range args {
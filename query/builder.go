@@ -0,0 +1,145 @@
+package query
+
+import (
+	"slices"
+	"strings"
+)
+
+// Term creates a term that matches values containing value, which
+// is literal so * and ? are not wildcards
+func Term(value string) *SearchTermNode {
+	return &SearchTermNode{Value: escapeGlob(value), MatchType: MatchTypeContains}
+}
+
+// Exact creates a term that matches the whole value
+func Exact(value string) *SearchTermNode {
+	return &SearchTermNode{Value: value, MatchType: MatchTypeExact}
+}
+
+// Pattern creates a term from a glob where * matches anything and ?
+// any single char, as if the user had typed it. Without a * it
+// matches values containing the glob, like a term.
+func Pattern(glob string) *SearchTermNode {
+	glob = canonicalGlob(glob)
+
+	if containsUnescaped(glob, '*') {
+		return &SearchTermNode{Value: glob, MatchType: MatchTypePattern}
+	}
+
+	return &SearchTermNode{Value: glob, MatchType: MatchTypeContains}
+}
+
+// Prefix creates a term that matches values starting with value
+func Prefix(value string) *PrefixNode {
+	return &PrefixNode{Value: value}
+}
+
+// Phrase creates a term that matches values containing the phrase
+func Phrase(value string) *PhraseNode {
+	return &PhraseNode{Value: value}
+}
+
+// Near creates a term that matches values with all the words
+// with at most distance other words between them
+func Near(distance int, words ...string) *ProximityNode {
+	return &ProximityNode{Words: words, Distance: distance}
+}
+
+// Compare creates a numeric comparison of a field
+func Compare(field string, op CompareOp, value float64) *ComparisonNode {
+	return &ComparisonNode{Field: field, Op: op, Value: value}
+}
+
+// Between creates an inclusive numeric range of a field
+func Between(field string, min float64, max float64) *RangeNode {
+	return &RangeNode{Field: field, Min: min, Max: max}
+}
+
+// In creates a node that matches any of the exact values of a field
+func In(field string, values ...string) *InNode {
+	return &InNode{Field: field, Values: values}
+}
+
+// On sets the field the term searches and returns the term so
+// that it can be chained, e.g. Exact("BCL6").On("gene")
+func (v *SearchTermNode) On(field string) *SearchTermNode {
+	v.Field = field
+	return v
+}
+
+func (n *PhraseNode) On(field string) *PhraseNode {
+	n.Field = field
+	return n
+}
+
+func (n *PrefixNode) On(field string) *PrefixNode {
+	n.Field = field
+	return n
+}
+
+func (n *ProximityNode) On(field string) *ProximityNode {
+	n.Field = field
+	return n
+}
+
+// Not negates a node
+func Not(n Node) Node {
+	return &NotNode{Child: n}
+}
+
+// And joins nodes with ANDs, skipping nil nodes so that optional
+// filters can be passed as is. It returns nil if all are nil.
+func And(nodes ...Node) Node {
+	return joinNodes(nodes, true)
+}
+
+// Or joins nodes with ORs, skipping nil nodes
+func Or(nodes ...Node) Node {
+	return joinNodes(nodes, false)
+}
+
+func joinNodes(nodes []Node, and bool) Node {
+	ops := make([]Node, 0, len(nodes))
+
+	for _, n := range nodes {
+		if n != nil {
+			ops = append(ops, n)
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	return join(ops, and)
+}
+
+// Constrain ANDs a tree parsed from an untrusted query with trusted
+// constraints, e.g. a tenant restriction. The tree is kept whole as
+// a single operand so that nothing the user typed can change how
+// the constraints apply, which string concatenation cannot promise,
+// e.g. appending " AND tenant:1" to "A OR B" only constrains B. A
+// nil tree, for an empty query, returns just the constraints.
+func Constrain(tree Node, constraints ...Node) Node {
+	return And(append(slices.Clone(constraints), tree)...)
+}
+
+// Escapes the wildcards and the escape char so that s is
+// matched literally as a glob
+func escapeGlob(s string) string {
+	if !strings.ContainsAny(s, `*?\`) {
+		return s
+	}
+
+	var b strings.Builder
+
+	for _, c := range s {
+		if c == '*' || c == '?' || c == EscapeChar {
+			b.WriteRune(EscapeChar)
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
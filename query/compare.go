@@ -0,0 +1,238 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+type (
+	CompareOp string
+
+	// ComparisonNode for numeric comparisons such as score>0.5
+	ComparisonNode struct {
+		Field string
+		Op    CompareOp
+		Value float64
+	}
+
+	// RangeNode for inclusive numeric ranges such as pos:1000..2000
+	RangeNode struct {
+		Field string
+		Min   float64
+		Max   float64
+	}
+)
+
+const (
+	OpGt CompareOp = ">"
+	OpGe CompareOp = ">="
+	OpLt CompareOp = "<"
+	OpLe CompareOp = "<="
+)
+
+var (
+	// matches closed and open ended ranges, 1..2, 1.. and ..2
+	rangeRegex = regexp.MustCompile(`^(-?\d+(?:\.\d+)?)?\.\.(-?\d+(?:\.\d+)?)?$`)
+
+	errComparisonField = fmt.Errorf("%w: comparisons need a field", ErrUnknownField)
+)
+
+func (c *ComparisonNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	column := b.compareColumn(c.Field)
+
+	return b.compare(b.addArg(numberArg(c.Value)), column, c.Op, addParens)
+}
+
+func (r *RangeNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	column := b.compareColumn(r.Field)
+
+	sql := b.compare(b.addArg(numberArg(r.Min)), column, OpGe, true) +
+		" AND " +
+		b.compare(b.addArg(numberArg(r.Max)), column, OpLe, true)
+
+	return AddParens(sql, addParens)
+}
+
+// Eval is true if any of the values of the field are numbers
+// that satisfy the comparison
+func (c *ComparisonNode) Eval(match Matcher) bool {
+	for _, v := range match(c.Field) {
+		n, err := strconv.ParseFloat(v, 64)
+
+		if err == nil && c.Op.compare(n, c.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *RangeNode) Eval(match Matcher) bool {
+	for _, v := range match(r.Field) {
+		n, err := strconv.ParseFloat(v, 64)
+
+		if err == nil && n >= r.Min && n <= r.Max {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (op CompareOp) compare(a float64, b float64) bool {
+	switch op {
+	case OpGt:
+		return a > b
+	case OpGe:
+		return a >= b
+	case OpLt:
+		return a < b
+	default:
+		return a <= b
+	}
+}
+
+// Parses a comparison such as score>0.5 or, if the field has
+// already been parsed from a field: prefix, >0.5. It returns
+// false if the input at the current position is not a comparison.
+func (p *Parser) parseComparison(field string) (Node, bool, error) {
+	end := p.pos
+
+	if field == "" {
+		for end < len(p.input) && isFieldChar(rune(p.input[end])) {
+			end++
+		}
+
+		field = p.input[p.pos:end]
+	}
+
+	if end >= len(p.input) || (p.input[end] != '<' && p.input[end] != '>') {
+		return nil, false, nil
+	}
+
+	field, err := p.compareField(field)
+
+	if err != nil {
+		return nil, true, err
+	}
+
+	p.pos = end
+
+	op := CompareOp(p.next())
+
+	if p.peek() == '=' {
+		p.next()
+		op += "="
+	}
+
+	start := p.pos
+
+	// params in templates, e.g. score>$min
+	if p.opts.params {
+		if name, ok := p.paramName(); ok {
+			return &ParamNode{Name: name, Target: &ComparisonNode{Field: field, Op: op}}, true, nil
+		}
+	}
+
+	if p.peek() == '-' {
+		p.next()
+	}
+
+	for isNumberChar(p.peek()) {
+		p.next()
+	}
+
+	value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+
+	if err != nil {
+		return nil, true, p.errorfAt(start, "expected number after %s", []string{"number"}, op)
+	}
+
+	return &ComparisonNode{Field: field, Op: op, Value: value}, true, nil
+}
+
+// Creates a range node if the value is a numeric range and there is
+// a field to apply it to, otherwise it is left as a search term.
+// Open ended ranges become comparisons.
+func (p *Parser) parseRange(field string, value string) (Node, bool, error) {
+	if p.opts.fields == nil {
+		return nil, false, nil
+	}
+
+	matches := rangeRegex.FindStringSubmatch(value)
+
+	if matches == nil || (matches[1] == "" && matches[2] == "") {
+		return nil, false, nil
+	}
+
+	if field == "" {
+		if _, ok := p.opts.fields.Column(""); !ok {
+			return nil, false, nil
+		}
+	}
+
+	// the regex guarantees these are numbers
+	min, _ := strconv.ParseFloat(matches[1], 64)
+	max, _ := strconv.ParseFloat(matches[2], 64)
+
+	switch {
+	case matches[1] == "":
+		return &ComparisonNode{Field: field, Op: OpLe, Value: max}, true, nil
+	case matches[2] == "":
+		return &ComparisonNode{Field: field, Op: OpGe, Value: min}, true, nil
+	case min > max:
+		return nil, true, fmt.Errorf("invalid range %s", value)
+	default:
+		return &RangeNode{Field: field, Min: min, Max: max}, true, nil
+	}
+}
+
+// Comparisons are always against a column so the field must be
+// whitelisted, or if there is no field, the fields must have a
+// default column under the empty name
+func (p *Parser) compareField(field string) (string, error) {
+	canonical, ok := p.opts.fields.canonical(field)
+
+	if ok {
+		return canonical, nil
+	}
+
+	if field == "" {
+		return "", errComparisonField
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownField, field)
+}
+
+// Comparisons must map to a real column
+func (b *SqlBuilder) compareColumn(field string) string {
+	column := b.column(field)
+
+	if column == "" {
+		b.fail(errComparisonField)
+	}
+
+	return column
+}
+
+// Numbers are bound as int64 if they are whole so that they can
+// be compared with integer columns by drivers that check types,
+// otherwise as float64
+func numberArg(n float64) any {
+	if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+		return int64(n)
+	}
+
+	return n
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// + is left out since it is the AND operator
+func isNumberChar(c rune) bool {
+	return (c >= '0' && c <= '9') || c == '.' || c == 'e' || c == 'E'
+}
@@ -0,0 +1,185 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-sys"
+)
+
+type (
+	// Dialect captures the differences between databases that
+	// matter when building sql from a tree so that one query
+	// string works across all of them
+	Dialect interface {
+		Name() string
+
+		// Placeholder returns the placeholder for the arg
+		// at index, which starts at 1
+		Placeholder(index int) string
+
+		// Positional is true if placeholders are bound in the
+		// order they appear and so cannot be reused, e.g. ? in
+		// MySQL, in which case args are repeated as needed
+		Positional() bool
+
+		// Like returns the case insensitive LIKE operator
+		Like() string
+
+		// Escape returns the ESCAPE clause that goes after
+		// LIKE patterns so that \ escapes wildcards
+		Escape() string
+
+		// LikePattern converts a search term value with * and ?
+		// wildcards into a LIKE pattern for the match type,
+		// escaping literal % and _ with \
+		LikePattern(value string, matchType MatchType) string
+	}
+
+	// Provides the LIKE pattern translation all the built in
+	// dialects share since they all use % and _ as wildcards
+	likeDialect struct{}
+
+	sqliteDialect struct{ likeDialect }
+
+	postgresDialect struct{ likeDialect }
+
+	mysqlDialect struct{ likeDialect }
+)
+
+var (
+	// Sqlite uses named params :p1, :p2 to match IndexedNamedArgs.
+	// LIKE is case insensitive for ASCII by default.
+	Sqlite Dialect = sqliteDialect{}
+
+	// Postgres uses $1, $2 and ILIKE since LIKE is case sensitive
+	Postgres Dialect = postgresDialect{}
+
+	// MySQL uses ? and LIKE which is case insensitive with
+	// the default collations
+	MySQL Dialect = mysqlDialect{}
+)
+
+// DialectFor returns the dialect for a database/sql driver name
+func DialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case sys.Sqlite3DB, "sqlite":
+		return Sqlite, nil
+	case sys.PostgresDB, "pgx":
+		return Postgres, nil
+	case sys.MySQLDB:
+		return MySQL, nil
+	default:
+		return nil, fmt.Errorf("no sql dialect for driver %q", driver)
+	}
+}
+
+func (likeDialect) LikePattern(value string, matchType MatchType) string {
+	return likePattern(value, matchType)
+}
+
+func (sqliteDialect) Name() string {
+	return sys.Sqlite3DB
+}
+
+func (sqliteDialect) Placeholder(index int) string {
+	return IndexedParam(index)
+}
+
+func (sqliteDialect) Positional() bool {
+	return false
+}
+
+func (sqliteDialect) Like() string {
+	return "LIKE"
+}
+
+// sqlite has no default escape char for LIKE
+func (sqliteDialect) Escape() string {
+	return `ESCAPE '\'`
+}
+
+func (postgresDialect) Name() string {
+	return sys.PostgresDB
+}
+
+func (postgresDialect) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+func (postgresDialect) Positional() bool {
+	return false
+}
+
+func (postgresDialect) Like() string {
+	return "ILIKE"
+}
+
+// assumes standard_conforming_strings which is the
+// default, so \ is not special in string literals
+func (postgresDialect) Escape() string {
+	return `ESCAPE '\'`
+}
+
+func (mysqlDialect) Name() string {
+	return sys.MySQLDB
+}
+
+func (mysqlDialect) Placeholder(index int) string {
+	return "?"
+}
+
+func (mysqlDialect) Positional() bool {
+	return true
+}
+
+func (mysqlDialect) Like() string {
+	return "LIKE"
+}
+
+// \ must itself be escaped in MySQL string literals
+func (mysqlDialect) Escape() string {
+	return `ESCAPE '\\'`
+}
+
+// Converts a search term value into a LIKE pattern. The * and ?
+// wildcards become % and _, and contains matches are wrapped in %.
+// Everything else is matched literally so % and _ are escaped, as
+// are wildcards the user escaped with a backslash. Exact values
+// are literals since they cannot have wildcards.
+func likePattern(value string, matchType MatchType) string {
+	if matchType == MatchTypeExact {
+		return EscapeLike(value)
+	}
+
+	var b strings.Builder
+
+	if matchType == MatchTypeContains {
+		b.WriteByte('%')
+	}
+
+	escaped := false
+
+	for _, c := range value {
+		switch {
+		case escaped:
+			b.WriteString(EscapeLike(string(c)))
+			escaped = false
+		case c == EscapeChar:
+			escaped = true
+		case c == '*':
+			b.WriteByte('%')
+		case c == '?':
+			b.WriteByte('_')
+		default:
+			b.WriteString(EscapeLike(string(c)))
+		}
+	}
+
+	if matchType == MatchTypeContains {
+		b.WriteByte('%')
+	}
+
+	return b.String()
+}
@@ -0,0 +1,226 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ElasticQuery compiles a tree into an Elasticsearch/OpenSearch bool
+// query, which is the value of the "query" key of a search request.
+// Terms keep their usual semantics and are case insensitive: exact
+// terms become term queries, contains terms become *value* wildcard
+// queries, terms with wildcards become wildcard queries and prefixes
+// prefix queries. Phrases become match_phrase queries, with a slop
+// for proximity. field:value terms search the column of the field
+// from WithFields, and plain terms search the columns from
+// WithColumns, or the default column of the fields, which should be
+// the names of fields in the index.
+func ElasticQuery(tree Node, opts ...Option) (map[string]any, error) {
+	return (&esCompiler{opts: newOptions(opts)}).compile(tree)
+}
+
+type esCompiler struct {
+	opts *options
+}
+
+func (c *esCompiler) compile(n Node) (map[string]any, error) {
+	switch n := n.(type) {
+	case *SearchTermNode:
+		return c.term(n)
+	case *NotNode:
+		child, err := c.compile(n.Child)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return esBool("must_not", []any{child}), nil
+	case *AndNode:
+		var must []any
+		var mustNot []any
+
+		for _, op := range flatten(n, true) {
+			// negated operands go straight into must_not
+			// rather than nesting another bool query
+			target := &must
+
+			if not, ok := op.(*NotNode); ok {
+				op = not.Child
+				target = &mustNot
+			}
+
+			clause, err := c.compile(op)
+
+			if err != nil {
+				return nil, err
+			}
+
+			*target = append(*target, clause)
+		}
+
+		q := map[string]any{}
+
+		if len(must) > 0 {
+			q["must"] = must
+		}
+
+		if len(mustNot) > 0 {
+			q["must_not"] = mustNot
+		}
+
+		return map[string]any{"bool": q}, nil
+	case *OrNode:
+		should, err := c.compileAll(flatten(n, false))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return esShould(should), nil
+	case *PhraseNode:
+		return c.match(n.Field, "match_phrase", map[string]any{"query": n.Value})
+	case *PrefixNode:
+		return c.match(n.Field, "prefix", map[string]any{"value": n.Value, "case_insensitive": true})
+	case *ProximityNode:
+		return c.match(n.Field, "match_phrase", map[string]any{"query": strings.Join(n.Words, " "), "slop": n.Distance})
+	case *ComparisonNode:
+		column, err := c.compareColumn(n.Field)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ops := map[CompareOp]string{OpGt: "gt", OpGe: "gte", OpLt: "lt", OpLe: "lte"}
+
+		return esField("range", column, map[string]any{ops[n.Op]: n.Value}), nil
+	case *RangeNode:
+		column, err := c.compareColumn(n.Field)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return esField("range", column, map[string]any{"gte": n.Min, "lte": n.Max}), nil
+	case *InNode:
+		// terms queries cannot ignore case like the exact
+		// terms the node stands for
+		return c.compile(n.or())
+	default:
+		return nil, fmt.Errorf("cannot compile %T to an elastic query", n)
+	}
+}
+
+func (c *esCompiler) compileAll(ops []Node) ([]any, error) {
+	ret := make([]any, 0, len(ops))
+
+	for _, op := range ops {
+		clause, err := c.compile(op)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, clause)
+	}
+
+	return ret, nil
+}
+
+func (c *esCompiler) term(n *SearchTermNode) (map[string]any, error) {
+	if n.MatchType == MatchTypeExact {
+		return c.match(n.Field, "term", map[string]any{"value": n.Value, "case_insensitive": true})
+	}
+
+	return c.match(n.Field, "wildcard", map[string]any{"value": esWildcard(n), "case_insensitive": true})
+}
+
+// Creates a query of the columns of a field
+func (c *esCompiler) match(field string, query string, params map[string]any) (map[string]any, error) {
+	columns, err := c.columns(field)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.perColumn(columns, func(column string) map[string]any {
+		return esField(query, column, params)
+	}), nil
+}
+
+// Terms that search several columns match if any of them do
+func (c *esCompiler) perColumn(columns []string, clause func(column string) map[string]any) map[string]any {
+	if len(columns) == 1 {
+		return clause(columns[0])
+	}
+
+	should := make([]any, 0, len(columns))
+
+	for _, column := range columns {
+		should = append(should, clause(column))
+	}
+
+	return esShould(should)
+}
+
+// Returns the index fields a term searches
+func (c *esCompiler) columns(field string) ([]string, error) {
+	if field != "" {
+		if c.opts.fields == nil {
+			return []string{field}, nil
+		}
+
+		column, ok := c.opts.fields.Column(field)
+
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+
+		return []string{column}, nil
+	}
+
+	if c.opts.fields != nil {
+		if column, ok := c.opts.fields.Column(""); ok && column != "" {
+			return []string{column}, nil
+		}
+	}
+
+	if len(c.opts.columns) == 0 {
+		return nil, errNoColumns
+	}
+
+	return c.opts.columns, nil
+}
+
+func (c *esCompiler) compareColumn(field string) (string, error) {
+	columns, err := c.columns(field)
+
+	if err != nil || len(columns) != 1 {
+		return "", errComparisonField
+	}
+
+	return columns[0], nil
+}
+
+// The wildcard syntax of elastic is the same as ours, * and ?
+// escaped with \, so values only need wrapping in * for contains
+func esWildcard(n *SearchTermNode) string {
+	if n.MatchType == MatchTypeContains {
+		return "*" + n.Value + "*"
+	}
+
+	return n.Value
+}
+
+func esField(query string, field string, value any) map[string]any {
+	return map[string]any{query: map[string]any{field: value}}
+}
+
+func esBool(occur string, clauses []any) map[string]any {
+	return map[string]any{"bool": map[string]any{occur: clauses}}
+}
+
+func esShould(clauses []any) map[string]any {
+	q := esBool("should", clauses)
+	q["bool"].(map[string]any)["minimum_should_match"] = 1
+	return q
+}
@@ -0,0 +1,123 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseError describes where and why a query could not be parsed
+// so that a UI can point at the problem. Offsets are byte offsets
+// into the query as the user typed it, not the normalized query
+// the parser works on.
+type ParseError struct {
+	Err error
+
+	// the query as the user typed it
+	Input string
+
+	// the token at the offset, empty at the end of the input
+	Token string
+
+	// what the parser would have accepted at the offset
+	Expected []string
+
+	// byte offset into Input
+	Offset int
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%v at position %d", e.Err, e.Offset)
+
+	if e.Token != "" {
+		fmt.Fprintf(&b, " near %q", e.Token)
+	}
+
+	if len(e.Expected) > 0 {
+		fmt.Fprintf(&b, ", expected %s", strings.Join(e.Expected, " or "))
+	}
+
+	return b.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Column returns the position of the error in runes rather than
+// bytes, which is what a UI needs to underline the problem
+func (e *ParseError) Column() int {
+	return utf8.RuneCountInString(e.Input[:min(e.Offset, len(e.Input))])
+}
+
+// Snippet returns the input with a caret under the problem, e.g.
+//
+//	A+(B,C
+//	      ^
+func (e *ParseError) Snippet() string {
+	return e.Input + "\n" + strings.Repeat(" ", e.Column()) + "^"
+}
+
+// Creates a parse error at a position of the normalized input
+func (p *Parser) errorAt(pos int, err error, expected ...string) error {
+	// errors from deeper down already have a position
+	var perr *ParseError
+
+	if errors.As(err, &perr) {
+		return err
+	}
+
+	offset := p.sourceOffset(pos)
+
+	return &ParseError{
+		Err:      err,
+		Input:    p.source,
+		Offset:   offset,
+		Token:    tokenAt(p.source, offset),
+		Expected: expected,
+	}
+}
+
+func (p *Parser) errorfAt(pos int, format string, expected []string, args ...any) error {
+	return p.errorAt(pos, fmt.Errorf(format, args...), expected...)
+}
+
+// Maps a position in the normalized input back to the input
+// the user typed
+func (p *Parser) sourceOffset(pos int) int {
+	if p.offsets == nil {
+		return pos
+	}
+
+	return p.offsets[min(pos, len(p.offsets)-1)]
+}
+
+// Returns the token starting at offset, which is a run of word
+// chars or a single char
+func tokenAt(s string, offset int) string {
+	if offset >= len(s) {
+		return ""
+	}
+
+	end := offset
+
+	for end < len(s) {
+		c, size := utf8.DecodeRuneInString(s[end:])
+
+		if !isWordChar(c) {
+			break
+		}
+
+		end += size
+	}
+
+	if end == offset {
+		_, size := utf8.DecodeRuneInString(s[offset:])
+		end += size
+	}
+
+	return s[offset:end]
+}
@@ -0,0 +1,140 @@
+package query
+
+import (
+	"strings"
+)
+
+// The escape char used in search terms to write wildcards and
+// quotes literally, e.g. \* or \", and in the LIKE patterns we
+// generate, which is why those need an ESCAPE '\' clause
+const EscapeChar = '\\'
+
+// EscapeLike escapes the LIKE wildcards % and _ and the escape
+// char itself so that s is matched literally
+func EscapeLike(s string) string {
+	if !strings.ContainsAny(s, `%_\`) {
+		return s
+	}
+
+	var b strings.Builder
+
+	for _, c := range s {
+		if c == '%' || c == '_' || c == EscapeChar {
+			b.WriteRune(EscapeChar)
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// EscapeTerm escapes the chars that have a special meaning in
+// unquoted search terms so that s can be searched for literally
+func EscapeTerm(s string) string {
+	var b strings.Builder
+
+	for _, c := range s {
+		if strings.ContainsRune(`*?\"`, c) {
+			b.WriteRune(EscapeChar)
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// unescape removes backslash escapes so that \* becomes *
+func unescape(s string) string {
+	if !strings.ContainsRune(s, EscapeChar) {
+		return s
+	}
+
+	var b strings.Builder
+
+	escaped := false
+
+	for _, c := range s {
+		if c == EscapeChar && !escaped {
+			escaped = true
+			continue
+		}
+
+		b.WriteRune(c)
+		escaped = false
+	}
+
+	// a trailing backslash has nothing to escape so keep it
+	if escaped {
+		b.WriteRune(EscapeChar)
+	}
+
+	return b.String()
+}
+
+// Canonical form of wildcard values where only the wildcards
+// and the escape char itself stay escaped, e.g. \a* becomes a*
+// but \** stays as is
+func canonicalGlob(s string) string {
+	if !strings.ContainsRune(s, EscapeChar) {
+		return s
+	}
+
+	var b strings.Builder
+
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		if c == EscapeChar {
+			if i+1 < len(runes) {
+				i++
+				c = runes[i]
+			}
+
+			if c == '*' || c == '?' || c == EscapeChar {
+				b.WriteRune(EscapeChar)
+			}
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// Reports whether s contains c other than escaped
+func containsUnescaped(s string, c rune) bool {
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == EscapeChar:
+			escaped = true
+		case r == c:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether s ends with c and it is not escaped,
+// i.e. it is preceded by an even number of backslashes
+func endsWithUnescaped(s string, c byte) bool {
+	if len(s) == 0 || s[len(s)-1] != c {
+		return false
+	}
+
+	n := 0
+
+	for i := len(s) - 2; i >= 0 && s[i] == EscapeChar; i-- {
+		n++
+	}
+
+	return n%2 == 0
+}
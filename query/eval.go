@@ -0,0 +1,132 @@
+package query
+
+import (
+	"unicode"
+)
+
+// Matcher returns the values of the record being evaluated that
+// a search term should be tested against, e.g. the gene symbol and
+// Ensembl id of a gene. The field is that of a field:value term,
+// which is empty for plain search terms.
+type Matcher func(field string) []string
+
+// MatchValues creates a matcher that tests every search term
+// against the same values
+func MatchValues(values ...string) Matcher {
+	return func(field string) []string {
+		return values
+	}
+}
+
+// Eval reports whether any of the record values match the term
+// using the same semantics as the sql LIKE clause it generates
+func (v *SearchTermNode) Eval(match Matcher) bool {
+	return matchAny(match(v.Field), likePattern(v.Value, v.MatchType))
+}
+
+// Reports whether any of the values match a LIKE pattern
+func matchAny(values []string, pattern string) bool {
+	for _, value := range values {
+		if likeMatch(pattern, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *NotNode) Eval(match Matcher) bool {
+	return !n.Child.Eval(match)
+}
+
+func (a *AndNode) Eval(match Matcher) bool {
+	return a.Left.Eval(match) && a.Right.Eval(match)
+}
+
+func (o *OrNode) Eval(match Matcher) bool {
+	return o.Left.Eval(match) || o.Right.Eval(match)
+}
+
+// Filter returns the items for which the tree evaluates to true
+func Filter[T any](tree Node, items []T, match func(item T) Matcher) []T {
+	ret := make([]T, 0, len(items))
+
+	for _, item := range items {
+		if tree.Eval(match(item)) {
+			ret = append(ret, item)
+		}
+	}
+
+	return ret
+}
+
+// likeMatch implements sql LIKE matching where % matches any run
+// of characters and _ any single character with \ as the escape
+// char. Like LIKE in sqlite, it is case insensitive.
+func likeMatch(pattern string, s string) bool {
+	p := []rune(pattern)
+	v := []rune(s)
+
+	// literal[i] is true if p[i] was escaped
+	p, literal := unescapeLike(p)
+
+	pi := 0
+	vi := 0
+
+	// position of the last % seen and the value
+	// position it is currently matched up to so we
+	// can backtrack if the rest fails to match
+	star := -1
+	starMatch := 0
+
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && p[pi] == '%' && !literal[pi]:
+			star = pi
+			starMatch = vi
+			pi++
+		case pi < len(p) && ((p[pi] == '_' && !literal[pi]) || equalFold(p[pi], v[vi])):
+			pi++
+			vi++
+		case star != -1:
+			// let the % absorb one more char and try again
+			pi = star + 1
+			starMatch++
+			vi = starMatch
+		default:
+			return false
+		}
+	}
+
+	// trailing %s match the empty string
+	for pi < len(p) && p[pi] == '%' && !literal[pi] {
+		pi++
+	}
+
+	return pi == len(p)
+}
+
+func equalFold(a rune, b rune) bool {
+	return a == b || unicode.ToLower(a) == unicode.ToLower(b)
+}
+
+// Removes the escape chars from a LIKE pattern, flagging which
+// of the remaining chars must be matched literally
+func unescapeLike(p []rune) ([]rune, []bool) {
+	ret := make([]rune, 0, len(p))
+	literal := make([]bool, 0, len(p))
+
+	for i := 0; i < len(p); i++ {
+		if p[i] == EscapeChar && i+1 < len(p) {
+			i++
+			ret = append(ret, p[i])
+			literal = append(literal, true)
+			continue
+		}
+
+		ret = append(ret, p[i])
+		literal = append(literal, false)
+	}
+
+	return ret, literal
+}
@@ -0,0 +1,60 @@
+package query
+
+import (
+	"errors"
+	"strings"
+)
+
+// Fields maps the field names users may search on, e.g. symbol,
+// to the sql columns they refer to, e.g. gex.gene_symbol
+type Fields map[string]string
+
+var ErrUnknownField = errors.New("unknown field")
+
+// Column returns the sql column for a field. Field names are
+// case insensitive.
+func (f Fields) Column(field string) (string, bool) {
+	if column, ok := f[field]; ok {
+		return column, true
+	}
+
+	for name, column := range f {
+		if strings.EqualFold(name, field) {
+			return column, true
+		}
+	}
+
+	return "", false
+}
+
+// Returns the name a field was declared with so that
+// trees always use the same spelling
+func (f Fields) canonical(field string) (string, bool) {
+	if _, ok := f[field]; ok {
+		return field, true
+	}
+
+	for name := range f {
+		if strings.EqualFold(name, field) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// MatchFields creates a matcher from the values of each field of
+// a record. Plain search terms are tested against the values of
+// the empty field.
+func MatchFields(values map[string][]string) Matcher {
+	return func(field string) []string {
+		return values[field]
+	}
+}
+
+func isFieldChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') ||
+		c == '_'
+}
@@ -0,0 +1,313 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned when a tree uses something that full
+// text search cannot express, such as a wildcard that is not at the
+// end of a term or a numeric comparison
+var ErrUnsupported = errors.New("not supported by full text search")
+
+// Fts5Query compiles a tree into an SQLite FTS5 MATCH expression,
+// which should be bound as a single param, e.g. genes_fts MATCH :p1.
+// Terms match tokens rather than substrings, quoted terms become
+// phrases and a trailing * makes a prefix query. FTS5 can only
+// exclude terms from something else so a NOT must be ANDed with a
+// term that is not negated. The column filter of field:value terms
+// is the column of the field if WithFields is given, otherwise the
+// field name as is.
+func Fts5Query(tree Node, opts ...Option) (string, error) {
+	return fts5Compile(tree, newOptions(opts))
+}
+
+// TsQuery compiles a tree into a Postgres tsquery string, which
+// should be bound as a single param to to_tsquery, e.g.
+// search @@ to_tsquery('english', $1). Quoted terms become phrases
+// using <-> and a trailing * makes a prefix query. tsvectors have
+// no columns so field:value terms are not supported.
+func TsQuery(tree Node) (string, error) {
+	switch n := tree.(type) {
+	case *SearchTermNode:
+		text, prefix, err := ftsText(n)
+
+		if err != nil {
+			return "", err
+		}
+
+		return tsPhrase(n.Field, strings.Fields(text), prefix, " <-> ")
+	case *PhraseNode:
+		return tsPhrase(n.Field, strings.Fields(n.Value), false, " <-> ")
+	case *PrefixNode:
+		return tsPhrase(n.Field, strings.Fields(n.Value), true, " <-> ")
+	case *ProximityNode:
+		// <N> is an exact distance rather than at most N so
+		// the best we can do is to require all the words
+		return tsPhrase(n.Field, n.Words, false, " & ")
+	case *NotNode:
+		child, err := TsQuery(n.Child)
+
+		if err != nil {
+			return "", err
+		}
+
+		return "!" + tsParens(n.Child, child), nil
+	case *AndNode:
+		return tsJunction(flatten(n, true), " & ")
+	case *OrNode:
+		return tsJunction(flatten(n, false), " | ")
+	case *InNode:
+		return TsQuery(n.or())
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, tree)
+	}
+}
+
+func tsJunction(ops []Node, op string) (string, error) {
+	parts := make([]string, 0, len(ops))
+
+	for _, o := range ops {
+		part, err := TsQuery(o)
+
+		if err != nil {
+			return "", err
+		}
+
+		parts = append(parts, tsParens(o, part))
+	}
+
+	return strings.Join(parts, op), nil
+}
+
+// Joins the words of a phrase, the last of which can be a prefix
+func tsPhrase(field string, words []string, prefix bool, op string) (string, error) {
+	if field != "" {
+		return "", fmt.Errorf("%w: field %s", ErrUnsupported, field)
+	}
+
+	if len(words) == 0 {
+		return "", fmt.Errorf("%w: empty search term", ErrUnsupported)
+	}
+
+	lexemes := make([]string, 0, len(words))
+
+	for _, w := range words {
+		lexemes = append(lexemes, tsLexeme(w))
+	}
+
+	if prefix {
+		lexemes[len(lexemes)-1] += ":*"
+	}
+
+	if len(lexemes) == 1 {
+		return lexemes[0], nil
+	}
+
+	return "(" + strings.Join(lexemes, op) + ")", nil
+}
+
+// ANDs and ORs need parens when nested, terms and phrases do not
+func tsParens(n Node, s string) string {
+	switch n.(type) {
+	case *AndNode, *OrNode, *InNode:
+		return "(" + s + ")"
+	default:
+		return s
+	}
+}
+
+// Quotes a word so that tsquery operators in it are literal
+func tsLexeme(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func fts5Compile(tree Node, o *options) (string, error) {
+	switch n := tree.(type) {
+	case *SearchTermNode:
+		text, prefix, err := ftsText(n)
+
+		if err != nil {
+			return "", err
+		}
+
+		s := fts5String(text)
+
+		if prefix {
+			s += "*"
+		}
+
+		return fts5Filter(n.Field, s, o)
+	case *PhraseNode:
+		return fts5Filter(n.Field, fts5String(n.Value), o)
+	case *PrefixNode:
+		return fts5Filter(n.Field, fts5String(n.Value)+"*", o)
+	case *ProximityNode:
+		phrases := make([]string, 0, len(n.Words))
+
+		for _, w := range n.Words {
+			phrases = append(phrases, fts5String(w))
+		}
+
+		return fts5Filter(n.Field, "NEAR("+strings.Join(phrases, " ")+", "+strconv.Itoa(n.Distance)+")", o)
+	case *NotNode:
+		return "", fmt.Errorf("%w: NOT must be ANDed with a term that is not negated", ErrUnsupported)
+	case *AndNode:
+		return fts5And(flatten(n, true), o)
+	case *OrNode:
+		return fts5Junction(flatten(n, false), " OR ", o)
+	case *InNode:
+		return fts5Compile(n.or(), o)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, tree)
+	}
+}
+
+// FTS5 NOT is binary, a NOT b, so the negated operands of an
+// AND are subtracted from the AND of the others
+func fts5And(ops []Node, o *options) (string, error) {
+	var include []Node
+	var exclude []Node
+
+	for _, op := range ops {
+		if not, ok := op.(*NotNode); ok {
+			exclude = append(exclude, not.Child)
+		} else {
+			include = append(include, op)
+		}
+	}
+
+	if len(include) == 0 {
+		return "", fmt.Errorf("%w: NOT must be ANDed with a term that is not negated", ErrUnsupported)
+	}
+
+	s, err := fts5Junction(include, " AND ", o)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(exclude) == 0 {
+		return s, nil
+	}
+
+	if len(include) > 1 {
+		s = "(" + s + ")"
+	}
+
+	for _, op := range exclude {
+		part, err := fts5Compile(op, o)
+
+		if err != nil {
+			return "", err
+		}
+
+		s += " NOT " + fts5Parens(op, part)
+	}
+
+	return s, nil
+}
+
+func fts5Junction(ops []Node, op string, o *options) (string, error) {
+	parts := make([]string, 0, len(ops))
+
+	for _, n := range ops {
+		part, err := fts5Compile(n, o)
+
+		if err != nil {
+			return "", err
+		}
+
+		parts = append(parts, fts5Parens(n, part))
+	}
+
+	return strings.Join(parts, op), nil
+}
+
+func fts5Parens(n Node, s string) string {
+	switch n.(type) {
+	case *SearchTermNode, *PhraseNode, *PrefixNode, *ProximityNode:
+		return s
+	default:
+		return "(" + s + ")"
+	}
+}
+
+// FTS5 strings are in double quotes with embedded quotes
+// doubled, which also makes keywords such as AND literal
+func fts5String(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Restricts an expression to the column of a field
+func fts5Filter(field string, s string, o *options) (string, error) {
+	if field == "" {
+		return s, nil
+	}
+
+	column, err := fts5Column(field, o)
+
+	if err != nil {
+		return "", err
+	}
+
+	return column + " : " + s, nil
+}
+
+func fts5Column(field string, o *options) (string, error) {
+	column := field
+
+	if o.fields != nil {
+		c, ok := o.fields.Column(field)
+
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+
+		column = c
+	}
+
+	// column filters must be barewords
+	for _, c := range column {
+		if !isFieldChar(c) {
+			return "", fmt.Errorf("%w: column %s", ErrUnsupported, column)
+		}
+	}
+
+	return column, nil
+}
+
+// Returns the literal text of a term and whether it is a prefix
+// query. Full text search matches whole tokens so the only
+// wildcard it supports is a trailing *.
+func ftsText(n *SearchTermNode) (string, bool, error) {
+	value := n.Value
+	prefix := false
+
+	switch n.MatchType {
+	case MatchTypeExact:
+		return value, false, nil
+	case MatchTypePattern:
+		if !endsWithUnescaped(value, '*') {
+			return "", false, fmt.Errorf("%w: wildcards must be at the end of %s", ErrUnsupported, value)
+		}
+
+		value = value[:len(value)-1]
+		prefix = true
+	}
+
+	if containsUnescaped(value, '*') || containsUnescaped(value, '?') {
+		return "", false, fmt.Errorf("%w: wildcards must be at the end of %s", ErrUnsupported, n.Value)
+	}
+
+	value = unescape(value)
+
+	if strings.TrimSpace(value) == "" {
+		return "", false, fmt.Errorf("%w: %s matches everything", ErrUnsupported, n.Value)
+	}
+
+	return value, prefix, nil
+}
@@ -0,0 +1,240 @@
+package query
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type (
+	// Span is the byte range [Start, End) of a match in a string
+	Span struct {
+		Start int
+		End   int
+	}
+
+	// Highlighter wraps the spans of matches in text with tags,
+	// which are written as is so must be trusted
+	Highlighter struct {
+		Open  string
+		Close string
+	}
+)
+
+// NewHighlighter creates a highlighter that uses <mark> tags
+func NewHighlighter() *Highlighter {
+	return &Highlighter{Open: "<mark>", Close: "</mark>"}
+}
+
+// Spans returns the parts of text that match the terms of a tree,
+// sorted and with overlapping spans merged. Only terms of the field
+// and plain terms are used, and negated terms are ignored since they
+// cannot have matched. Terms that match part of a value, such as
+// contains terms, phrases and prefixes, give the part they matched,
+// whereas terms that match the whole value, such as exact terms and
+// patterns, give the whole text.
+func Spans(tree Node, field string, text string) []Span {
+	var spans []Span
+
+	collectSpans(tree, field, text, false, &spans)
+
+	return mergeSpans(spans)
+}
+
+func collectSpans(n Node, field string, text string, negated bool, spans *[]Span) {
+	switch n := n.(type) {
+	case *NotNode:
+		collectSpans(n.Child, field, text, !negated, spans)
+		return
+	case *AndNode:
+		collectSpans(n.Left, field, text, negated, spans)
+		collectSpans(n.Right, field, text, negated, spans)
+		return
+	case *OrNode:
+		collectSpans(n.Left, field, text, negated, spans)
+		collectSpans(n.Right, field, text, negated, spans)
+		return
+	}
+
+	if negated || !hasField(n, field) {
+		return
+	}
+
+	match := MatchValues(text)
+
+	switch n := n.(type) {
+	case *SearchTermNode:
+		if n.MatchType == MatchTypeContains {
+			*spans = append(*spans, findLike(likePattern(n.Value, MatchTypePattern), text)...)
+		} else if n.Eval(match) {
+			*spans = append(*spans, Span{0, len(text)})
+		}
+	case *PhraseNode:
+		*spans = append(*spans, findLike(EscapeLike(n.Value), text)...)
+	case *PrefixNode:
+		if n.Eval(match) {
+			// the prefix has the same number of chars in the text
+			end := 0
+
+			for range utf8.RuneCountInString(n.Value) {
+				_, size := utf8.DecodeRuneInString(text[end:])
+				end += size
+			}
+
+			*spans = append(*spans, Span{0, end})
+		}
+	case *ProximityNode:
+		if n.Eval(match) {
+			*spans = append(*spans, wordSpans(text, n.Words)...)
+		}
+	default:
+		if n.Eval(match) {
+			*spans = append(*spans, Span{0, len(text)})
+		}
+	}
+}
+
+// Reports whether a term searches the field, which plain terms do
+func hasField(n Node, field string) bool {
+	var f string
+
+	switch n := n.(type) {
+	case *SearchTermNode:
+		f = n.Field
+	case *PhraseNode:
+		f = n.Field
+	case *PrefixNode:
+		f = n.Field
+	case *ProximityNode:
+		f = n.Field
+	case *ComparisonNode:
+		f = n.Field
+	case *RangeNode:
+		f = n.Field
+	case *InNode:
+		f = n.Field
+	}
+
+	return f == "" || f == field
+}
+
+// Finds the case insensitive matches of a LIKE pattern anywhere in
+// text. % matches as little as possible so that spans are tight.
+func findLike(pattern string, text string) []Span {
+	p, literal := unescapeLike([]rune(pattern))
+
+	var b strings.Builder
+
+	b.WriteString("(?is)")
+
+	for i, c := range p {
+		switch {
+		case c == '%' && !literal[i]:
+			b.WriteString(".*?")
+		case c == '_' && !literal[i]:
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	re, err := regexp.Compile(b.String())
+
+	if err != nil {
+		return nil
+	}
+
+	var spans []Span
+
+	for _, m := range re.FindAllStringIndex(text, -1) {
+		if m[1] > m[0] {
+			spans = append(spans, Span{m[0], m[1]})
+		}
+	}
+
+	return spans
+}
+
+// Returns the spans of the words of text that are one of words
+func wordSpans(text string, words []string) []Span {
+	want := make(map[string]struct{}, len(words))
+
+	for _, w := range words {
+		want[strings.ToLower(w)] = struct{}{}
+	}
+
+	var spans []Span
+
+	start := -1
+
+	for i, c := range text + " " {
+		isWord := unicode.IsLetter(c) || unicode.IsDigit(c)
+
+		switch {
+		case isWord && start == -1:
+			start = i
+		case !isWord && start != -1:
+			if _, ok := want[strings.ToLower(text[start:i])]; ok {
+				spans = append(spans, Span{start, i})
+			}
+
+			start = -1
+		}
+	}
+
+	return spans
+}
+
+func mergeSpans(spans []Span) []Span {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	slices.SortFunc(spans, func(a Span, b Span) int {
+		return a.Start - b.Start
+	})
+
+	ret := []Span{spans[0]}
+
+	for _, s := range spans[1:] {
+		last := &ret[len(ret)-1]
+
+		if s.Start <= last.End {
+			last.End = max(last.End, s.End)
+		} else {
+			ret = append(ret, s)
+		}
+	}
+
+	return ret
+}
+
+// Highlight returns text as HTML with the spans, which must be sorted
+// and not overlap as they are from Spans, wrapped in the tags.
+// The text is escaped so it is safe to show whatever it contains.
+func (h *Highlighter) Highlight(text string, spans []Span) string {
+	var b strings.Builder
+
+	pos := 0
+
+	for _, s := range spans {
+		b.WriteString(html.EscapeString(text[pos:s.Start]))
+		b.WriteString(h.Open)
+		b.WriteString(html.EscapeString(text[s.Start:s.End]))
+		b.WriteString(h.Close)
+		pos = s.End
+	}
+
+	b.WriteString(html.EscapeString(text[pos:]))
+
+	return b.String()
+}
+
+// HighlightTree highlights the parts of text that match a tree,
+// see Spans
+func (h *Highlighter) HighlightTree(tree Node, field string, text string) string {
+	return h.Highlight(text, Spans(tree, field, text))
+}
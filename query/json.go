@@ -0,0 +1,439 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The discriminators that say which node a json object is
+const (
+	NodeTypeTerm      = "term"
+	NodeTypeNot       = "not"
+	NodeTypeAnd       = "and"
+	NodeTypeOr        = "or"
+	NodeTypeCompare   = "compare"
+	NodeTypeRange     = "range"
+	NodeTypeIn        = "in"
+	NodeTypePhrase    = "phrase"
+	NodeTypePrefix    = "prefix"
+	NodeTypeProximity = "proximity"
+	NodeTypeParam     = "param"
+)
+
+type (
+	termJSON struct {
+		Type      string    `json:"type"`
+		Field     string    `json:"field,omitempty"`
+		Value     string    `json:"value"`
+		MatchType MatchType `json:"match"`
+	}
+
+	notJSON struct {
+		Type  string          `json:"type"`
+		Child json.RawMessage `json:"child"`
+	}
+
+	binaryJSON struct {
+		Type  string          `json:"type"`
+		Left  json.RawMessage `json:"left"`
+		Right json.RawMessage `json:"right"`
+	}
+
+	compareJSON struct {
+		Type  string    `json:"type"`
+		Field string    `json:"field,omitempty"`
+		Op    CompareOp `json:"op"`
+		Value float64   `json:"value"`
+	}
+
+	// phrases and prefixes
+	textJSON struct {
+		Type  string `json:"type"`
+		Field string `json:"field,omitempty"`
+		Value string `json:"value"`
+	}
+
+	proximityJSON struct {
+		Type     string   `json:"type"`
+		Field    string   `json:"field,omitempty"`
+		Words    []string `json:"words"`
+		Distance int      `json:"distance"`
+	}
+
+	inJSON struct {
+		Type   string   `json:"type"`
+		Field  string   `json:"field,omitempty"`
+		Values []string `json:"values"`
+	}
+
+	rangeJSON struct {
+		Type  string  `json:"type"`
+		Field string  `json:"field,omitempty"`
+		Min   float64 `json:"min"`
+		Max   float64 `json:"max"`
+	}
+)
+
+var errMissingNode = errors.New("missing node")
+
+// UnmarshalNode creates a tree from json created by marshaling
+// a node, using the type of each object to pick the node
+func UnmarshalNode(data []byte) (Node, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+
+	var node Node
+
+	switch head.Type {
+	case NodeTypeTerm:
+		node = &SearchTermNode{}
+	case NodeTypeNot:
+		node = &NotNode{}
+	case NodeTypeAnd:
+		node = &AndNode{}
+	case NodeTypeOr:
+		node = &OrNode{}
+	case NodeTypeCompare:
+		node = &ComparisonNode{}
+	case NodeTypeRange:
+		node = &RangeNode{}
+	case NodeTypeIn:
+		node = &InNode{}
+	case NodeTypePhrase:
+		node = &PhraseNode{}
+	case NodeTypePrefix:
+		node = &PrefixNode{}
+	case NodeTypeProximity:
+		node = &ProximityNode{}
+	case NodeTypeParam:
+		node = &ParamNode{}
+	case "":
+		return nil, errMissingNode
+	default:
+		return nil, fmt.Errorf("unknown node type %q", head.Type)
+	}
+
+	if err := json.Unmarshal(data, node); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Unmarshals a child, which must be present
+func unmarshalChild(data json.RawMessage) (Node, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, errMissingNode
+	}
+
+	return UnmarshalNode(data)
+}
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchTypeExact:
+		return "exact"
+	case MatchTypePattern:
+		return "pattern"
+	default:
+		return "contains"
+	}
+}
+
+func (t MatchType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *MatchType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "contains":
+		*t = MatchTypeContains
+	case "exact":
+		*t = MatchTypeExact
+	case "pattern":
+		*t = MatchTypePattern
+	default:
+		return fmt.Errorf("unknown match type %q", text)
+	}
+
+	return nil
+}
+
+func (op *CompareOp) UnmarshalText(text []byte) error {
+	switch CompareOp(text) {
+	case OpGt, OpGe, OpLt, OpLe:
+		*op = CompareOp(text)
+		return nil
+	default:
+		return fmt.Errorf("unknown comparison %q", text)
+	}
+}
+
+func (v *SearchTermNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(termJSON{Type: NodeTypeTerm, Field: v.Field, Value: v.Value, MatchType: v.MatchType})
+}
+
+func (v *SearchTermNode) UnmarshalJSON(data []byte) error {
+	var t termJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	if t.Value == "" {
+		return errors.New("empty search term")
+	}
+
+	*v = SearchTermNode{Field: t.Field, Value: t.Value, MatchType: t.MatchType}
+
+	return nil
+}
+
+func (n *NotNode) MarshalJSON() ([]byte, error) {
+	child, err := json.Marshal(n.Child)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(notJSON{Type: NodeTypeNot, Child: child})
+}
+
+func (n *NotNode) UnmarshalJSON(data []byte) error {
+	var t notJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	child, err := unmarshalChild(t.Child)
+
+	if err != nil {
+		return err
+	}
+
+	n.Child = child
+
+	return nil
+}
+
+func (a *AndNode) MarshalJSON() ([]byte, error) {
+	return marshalBinary(NodeTypeAnd, a.Left, a.Right)
+}
+
+func (a *AndNode) UnmarshalJSON(data []byte) error {
+	left, right, err := unmarshalBinary(data)
+
+	if err != nil {
+		return err
+	}
+
+	*a = AndNode{Left: left, Right: right}
+
+	return nil
+}
+
+func (o *OrNode) MarshalJSON() ([]byte, error) {
+	return marshalBinary(NodeTypeOr, o.Left, o.Right)
+}
+
+func (o *OrNode) UnmarshalJSON(data []byte) error {
+	left, right, err := unmarshalBinary(data)
+
+	if err != nil {
+		return err
+	}
+
+	*o = OrNode{Left: left, Right: right}
+
+	return nil
+}
+
+func (c *ComparisonNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compareJSON{Type: NodeTypeCompare, Field: c.Field, Op: c.Op, Value: c.Value})
+}
+
+func (c *ComparisonNode) UnmarshalJSON(data []byte) error {
+	var t compareJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	if err := checkComparison(t.Field, t.Op); err != nil {
+		return err
+	}
+
+	*c = ComparisonNode{Field: t.Field, Op: t.Op, Value: t.Value}
+
+	return nil
+}
+
+// The op is only checked by UnmarshalText if it is present, so
+// a missing op must be caught here, and like the parser a
+// comparison must have a field
+func checkComparison(field string, op CompareOp) error {
+	if op == "" {
+		return errors.New("missing comparison")
+	}
+
+	if field == "" {
+		return errComparisonField
+	}
+
+	return nil
+}
+
+func (r *RangeNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rangeJSON{Type: NodeTypeRange, Field: r.Field, Min: r.Min, Max: r.Max})
+}
+
+func (r *RangeNode) UnmarshalJSON(data []byte) error {
+	var t rangeJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	if t.Min > t.Max {
+		return fmt.Errorf("invalid range %s..%s", formatNumber(t.Min), formatNumber(t.Max))
+	}
+
+	*r = RangeNode{Field: t.Field, Min: t.Min, Max: t.Max}
+
+	return nil
+}
+
+func (n *InNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(inJSON{Type: NodeTypeIn, Field: n.Field, Values: n.Values})
+}
+
+func (n *InNode) UnmarshalJSON(data []byte) error {
+	var t inJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	if len(t.Values) == 0 {
+		return errors.New("empty in list")
+	}
+
+	*n = InNode{Field: t.Field, Values: t.Values}
+
+	return nil
+}
+
+func (n *PhraseNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(textJSON{Type: NodeTypePhrase, Field: n.Field, Value: n.Value})
+}
+
+func (n *PhraseNode) UnmarshalJSON(data []byte) error {
+	field, value, err := unmarshalText(data)
+
+	if err != nil {
+		return err
+	}
+
+	*n = PhraseNode{Field: field, Value: value}
+
+	return nil
+}
+
+func (n *PrefixNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(textJSON{Type: NodeTypePrefix, Field: n.Field, Value: n.Value})
+}
+
+func (n *PrefixNode) UnmarshalJSON(data []byte) error {
+	field, value, err := unmarshalText(data)
+
+	if err != nil {
+		return err
+	}
+
+	*n = PrefixNode{Field: field, Value: value}
+
+	return nil
+}
+
+func (n *ProximityNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(proximityJSON{Type: NodeTypeProximity, Field: n.Field, Words: n.Words, Distance: n.Distance})
+}
+
+func (n *ProximityNode) UnmarshalJSON(data []byte) error {
+	var t proximityJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	if len(t.Words) == 0 {
+		return errors.New("empty search term")
+	}
+
+	if t.Distance < 0 || t.Distance > MaxProximityDistance {
+		return fmt.Errorf("invalid distance %d", t.Distance)
+	}
+
+	*n = ProximityNode{Field: t.Field, Words: t.Words, Distance: t.Distance}
+
+	return nil
+}
+
+func unmarshalText(data []byte) (string, string, error) {
+	var t textJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return "", "", err
+	}
+
+	if t.Value == "" {
+		return "", "", errors.New("empty search term")
+	}
+
+	return t.Field, t.Value, nil
+}
+
+func marshalBinary(nodeType string, left Node, right Node) ([]byte, error) {
+	l, err := json.Marshal(left)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := json.Marshal(right)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(binaryJSON{Type: nodeType, Left: l, Right: r})
+}
+
+func unmarshalBinary(data []byte) (Node, Node, error) {
+	var t binaryJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, nil, err
+	}
+
+	left, err := unmarshalChild(t.Left)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	right, err := unmarshalChild(t.Right)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return left, right, nil
+}
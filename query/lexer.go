@@ -0,0 +1,415 @@
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/antonybholmes/go-sys"
+)
+
+type (
+	TokenType int
+
+	// Token is a piece of a query as the user typed it, e.g. for
+	// syntax highlighting. Start and End are the byte range
+	// [Start, End) of Text in the query.
+	Token struct {
+		Type  TokenType `json:"type"`
+		Text  string    `json:"text"`
+		Start int       `json:"start"`
+		End   int       `json:"end"`
+	}
+
+	// Completion describes what can be typed at a cursor. If the
+	// cursor is at the end of a partly typed term, Prefix is what has
+	// been typed of it from Start, which a suggestion should replace,
+	// otherwise Prefix is empty and Start is the cursor.
+	Completion struct {
+		// the kinds of token that are valid at the cursor
+		Expected []TokenType `json:"expected"`
+
+		// the names from WithFields that start with Prefix, if a
+		// field is valid at the cursor
+		Fields []string `json:"fields"`
+
+		Prefix string `json:"prefix"`
+		Start  int    `json:"start"`
+	}
+)
+
+const (
+	// a search term, including wildcards, ranges and numbers
+	TokenTypeTerm TokenType = iota
+	// a field and its colon, e.g. symbol:, or the field of a
+	// comparison, e.g. score in score>0.5
+	TokenTypeField
+	// +, ",", !, -, AND, OR, NOT and the comparisons <, <=, > and >=
+	TokenTypeOperator
+	// ( or )
+	TokenTypeParen
+	// a quoted phrase including its quotes, with the = of an exact
+	// match or the ~N of a proximity search. Quotes that are not
+	// closed run to the end of the query.
+	TokenTypeQuote
+	// chars that are not part of the syntax, e.g. a stray ~
+	TokenTypeInvalid
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case TokenTypeField:
+		return "field"
+	case TokenTypeOperator:
+		return "operator"
+	case TokenTypeParen:
+		return "paren"
+	case TokenTypeQuote:
+		return "quote"
+	case TokenTypeInvalid:
+		return "invalid"
+	default:
+		return "term"
+	}
+}
+
+func (t TokenType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *TokenType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "term":
+		*t = TokenTypeTerm
+	case "field":
+		*t = TokenTypeField
+	case "operator":
+		*t = TokenTypeOperator
+	case "paren":
+		*t = TokenTypeParen
+	case "quote":
+		*t = TokenTypeQuote
+	case "invalid":
+		*t = TokenTypeInvalid
+	default:
+		return fmt.Errorf("unknown token type %q", text)
+	}
+
+	return nil
+}
+
+// Tokenize splits a query into tokens the way the parser sees it,
+// but it never fails so that queries can be highlighted as they are
+// typed. Whitespace is skipped. The options that change the syntax,
+// WithFields and WithCaseInsensitiveKeywords, should match those
+// used to parse the query.
+func Tokenize(input string, opts ...Option) []Token {
+	l := lexer{opts: newOptions(opts), input: input}
+
+	for l.pos < len(l.input) {
+		l.lex()
+	}
+
+	return l.tokens
+}
+
+type lexer struct {
+	opts   *options
+	input  string
+	pos    int
+	tokens []Token
+}
+
+func (l *lexer) peek(offset int) rune {
+	if l.pos+offset >= len(l.input) {
+		return 0
+	}
+
+	c, _ := utf8.DecodeRuneInString(l.input[l.pos+offset:])
+	return c
+}
+
+// Adds a token that runs from the current position to end
+func (l *lexer) emit(t TokenType, end int) {
+	l.tokens = append(l.tokens, Token{Type: t, Text: l.input[l.pos:end], Start: l.pos, End: end})
+	l.pos = end
+}
+
+// The last token if it is a comparison, whose number can be negative
+func (l *lexer) afterComparison() bool {
+	if len(l.tokens) == 0 {
+		return false
+	}
+
+	last := l.tokens[len(l.tokens)-1]
+
+	return last.Type == TokenTypeOperator && last.End == l.pos && strings.ContainsAny(last.Text, "<>")
+}
+
+func (l *lexer) lex() {
+	c, size := utf8.DecodeRuneInString(l.input[l.pos:])
+
+	switch {
+	case unicode.IsSpace(c):
+		l.pos += size
+	case c == '(' || c == ')':
+		l.emit(TokenTypeParen, l.pos+1)
+	case c == '+' || c == ',' || c == '!':
+		l.emit(TokenTypeOperator, l.pos+1)
+	case c == '-' && !l.afterComparison():
+		l.emit(TokenTypeOperator, l.pos+1)
+	case c == '<' || c == '>':
+		end := l.pos + 1
+
+		if l.peek(1) == '=' {
+			end++
+		}
+
+		l.emit(TokenTypeOperator, end)
+	case c == '"' || (c == '=' && l.peek(1) == '"'):
+		l.emit(TokenTypeQuote, l.quoteEnd())
+	case isWordChar(c):
+		l.word()
+	default:
+		l.emit(TokenTypeInvalid, l.pos+size)
+	}
+}
+
+// Returns the end of the quote at the current position
+// including its ~N if it has one
+func (l *lexer) quoteEnd() int {
+	end := l.pos + 1
+
+	if l.input[l.pos] == '=' {
+		end++
+	}
+
+	for end < len(l.input) && l.input[end] != '"' {
+		if l.input[end] == EscapeChar {
+			end++
+		}
+
+		end++
+	}
+
+	if end >= len(l.input) {
+		return len(l.input)
+	}
+
+	// skip the closing quote
+	end++
+
+	if end < len(l.input) && l.input[end] == '~' {
+		end++
+
+		for end < len(l.input) && l.input[end] >= '0' && l.input[end] <= '9' {
+			end++
+		}
+	}
+
+	return end
+}
+
+// Lexes a field, boolean word or search term
+func (l *lexer) word() {
+	// fields as the parser sees them, field: if there are fields
+	// and comparison fields such as score> whether or not there are
+	end := l.pos
+
+	for end < len(l.input) && isFieldChar(rune(l.input[end])) {
+		end++
+	}
+
+	if end > l.pos && end < len(l.input) {
+		switch {
+		case l.input[end] == ':' && l.opts.fields != nil:
+			l.emit(TokenTypeField, end+1)
+			return
+		case l.input[end] == '<' || l.input[end] == '>':
+			l.emit(TokenTypeField, end)
+			return
+		}
+	}
+
+	if end, ok := l.keyword(); ok {
+		l.emit(TokenTypeOperator, end)
+		return
+	}
+
+	end = l.pos
+
+	for end < len(l.input) {
+		c, size := utf8.DecodeRuneInString(l.input[end:])
+
+		if !isWordChar(c) {
+			break
+		}
+
+		end += size
+
+		// escaped chars are always part of the term
+		if c == EscapeChar && end < len(l.input) {
+			_, size = utf8.DecodeRuneInString(l.input[end:])
+			end += size
+		}
+	}
+
+	l.emit(TokenTypeTerm, end)
+}
+
+// Returns the end of the boolean word at the current position
+// using the same rules as normalizeBooleanWordsOffsets
+func (l *lexer) keyword() (int, bool) {
+	end := l.pos
+
+	for end < len(l.input) && sys.IsLetter(l.input[end]) {
+		end++
+	}
+
+	word := l.input[l.pos:end]
+
+	if l.opts.foldKeywords {
+		word = strings.ToUpper(word)
+	}
+
+	if word != "AND" && word != "OR" && word != "NOT" {
+		return 0, false
+	}
+
+	before, _ := utf8.DecodeLastRuneInString(l.input[:l.pos])
+	after, _ := utf8.DecodeRuneInString(l.input[end:])
+
+	if (l.pos > 0 && isWordChar(before)) || (end < len(l.input) && isWordChar(after)) {
+		return 0, false
+	}
+
+	return end, true
+}
+
+// Complete reports what can be typed at a cursor, which is a byte
+// offset into the query, for autocompletion. It looks at the tokens
+// before the cursor so the query does not have to be valid. Known
+// field names come from WithFields.
+func Complete(input string, cursor int, opts ...Option) *Completion {
+	o := newOptions(opts)
+
+	cursor = max(0, min(cursor, len(input)))
+
+	tokens := Tokenize(input[:cursor], opts...)
+
+	ret := &Completion{Start: cursor}
+
+	// a term that the cursor is at the end of is still being typed,
+	// perhaps as a field, so what can come next depends on what is
+	// before it
+	if n := len(tokens); n > 0 {
+		last := tokens[n-1]
+
+		switch {
+		case last.Type == TokenTypeQuote && !isClosedQuote(last.Text):
+			// only the closing quote can end a phrase
+			ret.Expected = []TokenType{TokenTypeQuote}
+			return ret
+		case last.Type == TokenTypeTerm && last.End == cursor:
+			ret.Prefix = last.Text
+			ret.Start = last.Start
+			tokens = tokens[:n-1]
+		}
+	}
+
+	depth := 0
+
+	for _, t := range tokens {
+		switch t.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+	}
+
+	var last *Token
+
+	if len(tokens) > 0 {
+		last = &tokens[len(tokens)-1]
+	}
+
+	operand := false
+
+	switch {
+	case last == nil, last.Type == TokenTypeInvalid:
+		operand = true
+	case last.Type == TokenTypeField:
+		// a value or, e.g. score:>, a comparison
+		ret.Expected = []TokenType{TokenTypeTerm, TokenTypeQuote, TokenTypeOperator}
+	case last.Type == TokenTypeOperator && strings.ContainsAny(last.Text, "<>"):
+		ret.Expected = []TokenType{TokenTypeTerm}
+	case last.Type == TokenTypeOperator, last.Text == "(":
+		operand = true
+	default:
+		// after a term, quote or ) comes an operator or, since
+		// spaces are implicit ANDs, another operand
+		ret.Expected = []TokenType{TokenTypeOperator}
+
+		if depth > 0 {
+			ret.Expected = append(ret.Expected, TokenTypeParen)
+		}
+
+		if last.End < ret.Start {
+			operand = true
+		}
+	}
+
+	if operand {
+		for _, t := range []TokenType{TokenTypeTerm, TokenTypeField, TokenTypeQuote, TokenTypeParen, TokenTypeOperator} {
+			if t == TokenTypeField && o.fields == nil {
+				continue
+			}
+
+			if !slices.Contains(ret.Expected, t) {
+				ret.Expected = append(ret.Expected, t)
+			}
+		}
+
+		ret.Fields = completeFields(o.fields, ret.Prefix)
+	}
+
+	slices.Sort(ret.Expected)
+
+	return ret
+}
+
+// Reports whether a quote token has its closing quote
+func isClosedQuote(s string) bool {
+	s = strings.TrimPrefix(s, "=")
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case EscapeChar:
+			i++
+		case '"':
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the names of the fields that start with prefix ignoring
+// case, sorted for display. The default column has no name so it
+// cannot be typed.
+func completeFields(fields Fields, prefix string) []string {
+	var ret []string
+
+	for name := range fields {
+		if name != "" && len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			ret = append(ret, name)
+		}
+	}
+
+	slices.Sort(ret)
+
+	return ret
+}
@@ -0,0 +1,139 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// LimitError is returned, wrapped in a ParseError pointing at where
+// the limit was crossed, when a query is too complex. Err is one of
+// ErrTooLong, ErrTooManyTerms, ErrTooDeep or ErrLeadingWildcards.
+type LimitError struct {
+	Err   error
+	Limit int
+}
+
+// NoLimit turns a limit off
+const NoLimit = -1
+
+// DefaultMaxDepth stops deeply nested parens from
+// overflowing the stack of the recursive parser
+const DefaultMaxDepth = 100
+
+var (
+	ErrTooLong          = errors.New("query is too long")
+	ErrTooManyTerms     = errors.New("query has too many search terms")
+	ErrTooDeep          = errors.New("query is nested too deeply")
+	ErrLeadingWildcards = errors.New("query has too many search terms starting with a wildcard")
+)
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v, the limit is %d", e.Err, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// WithMaxLength limits the length of a query in chars
+func WithMaxLength(max int) Option {
+	return func(o *options) {
+		o.maxLength = max
+	}
+}
+
+// WithMaxTerms limits the number of search terms, comparisons
+// and ranges in a query
+func WithMaxTerms(max int) Option {
+	return func(o *options) {
+		o.maxTerms = max
+	}
+}
+
+// WithMaxDepth limits how deeply parens can be nested. It defaults
+// to DefaultMaxDepth.
+func WithMaxDepth(max int) Option {
+	return func(o *options) {
+		o.maxDepth = max
+	}
+}
+
+// WithMaxLeadingWildcards limits the number of terms that start
+// with a * or ? wildcard, which stop the database from using an
+// index. Zero forbids them. Since they are LIKE '%term%', plain
+// contains terms cannot use an index either but are not counted.
+func WithMaxLeadingWildcards(max int) Option {
+	return func(o *options) {
+		o.maxLeadingWildcards = max
+	}
+}
+
+func exceeds(n int, max int) bool {
+	return max != NoLimit && n > max
+}
+
+func (p *Parser) checkLength() error {
+	max := p.opts.maxLength
+
+	// there cannot be more chars than bytes so most
+	// queries do not need counting
+	if max == NoLimit || len(p.source) <= max || !exceeds(utf8.RuneCountInString(p.source), max) {
+		return nil
+	}
+
+	// point at the first char over the limit
+	offset := 0
+
+	for range max {
+		_, size := utf8.DecodeRuneInString(p.source[offset:])
+		offset += size
+	}
+
+	return &ParseError{
+		Err:    &LimitError{Err: ErrTooLong, Limit: max},
+		Input:  p.source,
+		Offset: offset,
+		Token:  tokenAt(p.source, offset),
+	}
+}
+
+// Called on entering parens
+func (p *Parser) enter() error {
+	p.depth++
+
+	if exceeds(p.depth, p.opts.maxDepth) {
+		return p.errorAt(p.pos, &LimitError{Err: ErrTooDeep, Limit: p.opts.maxDepth})
+	}
+
+	return nil
+}
+
+func (p *Parser) leave() {
+	p.depth--
+}
+
+// Counts a term parsed at pos against the limits
+func (p *Parser) countTerm(node Node, pos int) error {
+	p.terms++
+
+	if exceeds(p.terms, p.opts.maxTerms) {
+		return p.errorAt(pos, &LimitError{Err: ErrTooManyTerms, Limit: p.opts.maxTerms})
+	}
+
+	if term, ok := node.(*SearchTermNode); ok && hasLeadingWildcard(term) {
+		p.leadingWildcards++
+
+		if exceeds(p.leadingWildcards, p.opts.maxLeadingWildcards) {
+			return p.errorAt(pos, &LimitError{Err: ErrLeadingWildcards, Limit: p.opts.maxLeadingWildcards})
+		}
+	}
+
+	return nil
+}
+
+func hasLeadingWildcard(term *SearchTermNode) bool {
+	return term.MatchType != MatchTypeExact &&
+		len(term.Value) > 0 &&
+		(term.Value[0] == '*' || term.Value[0] == '?')
+}
@@ -0,0 +1,111 @@
+package query
+
+import "golang.org/x/text/unicode/norm"
+
+type (
+	// Option configures how queries are parsed and compiled
+	Option func(*options)
+
+	options struct {
+		dialect Dialect
+		fields  Fields
+		compare SqlCompareClauseFunc
+		columns []string
+
+		// escape the patterns given to clauses from the caller
+		escapeLike bool
+
+		// used by Simplify
+		normalForm NormalForm
+		maxClauses int
+		inLists    bool
+
+		// used by SanitizeQuery
+		charPolicy  CharPolicy
+		unicodeForm norm.Form
+
+		// accept and, or and not in any case
+		foldKeywords bool
+
+		// parse $name as a param, see ParseTemplate
+		params bool
+
+		// parser limits
+		maxLength           int
+		maxTerms            int
+		maxDepth            int
+		maxLeadingWildcards int
+	}
+)
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		maxLength:           NoLimit,
+		maxTerms:            NoLimit,
+		maxDepth:            DefaultMaxDepth,
+		maxClauses:          DefaultMaxClauses,
+		maxLeadingWildcards: NoLimit,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithFields enables field:value search terms restricted to
+// the given fields. Without it, : is a plain search term char
+// so that values such as chr3:1000 keep working.
+func WithFields(fields Fields) Option {
+	return func(o *options) {
+		o.fields = fields
+	}
+}
+
+// WithCompareClause sets the function used to create the sql for
+// numeric comparisons, e.g. to cast the column first
+func WithCompareClause(compare SqlCompareClauseFunc) Option {
+	return func(o *options) {
+		o.compare = compare
+	}
+}
+
+// WithDialect sets the database dialect used for placeholders,
+// LIKE operators and patterns. Clauses supplied by the caller
+// should use the dialect placeholders too, bearing in mind that
+// positional placeholders such as MySQL ? cannot be reused.
+func WithDialect(dialect Dialect) Option {
+	return func(o *options) {
+		o.dialect = dialect
+	}
+}
+
+// WithLikeEscape escapes literal %, _ and \ in the LIKE patterns
+// given to clauses supplied by the caller so that they are matched
+// literally. Clauses must then add an ESCAPE '\' clause, see
+// Dialect.Escape, since sqlite, for one, has no default escape char.
+// Without it the escapes are left out so that existing clauses keep
+// working. Clauses the builder creates itself are always escaped.
+func WithLikeEscape() Option {
+	return func(o *options) {
+		o.escapeLike = true
+	}
+}
+
+// WithCaseInsensitiveKeywords lets users type the boolean words
+// AND, OR and NOT in any case, e.g. a and not b. It is off by
+// default since lowercase words are more likely to be terms.
+func WithCaseInsensitiveKeywords() Option {
+	return func(o *options) {
+		o.foldKeywords = true
+	}
+}
+
+// WithColumns sets the columns plain search terms are matched
+// against when sql is built without a clause function
+func WithColumns(columns ...string) Option {
+	return func(o *options) {
+		o.columns = columns
+	}
+}
@@ -0,0 +1,277 @@
+package query
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	// PhraseNode for quoted terms such as "B cell", which match if
+	// the value contains the phrase anywhere, e.g. in a longer
+	// description. Value is the phrase with escapes removed.
+	PhraseNode struct {
+		Field string
+		Value string
+	}
+
+	// PrefixNode for terms with a single trailing wildcard such as
+	// BCL*, which match values that start with Value. Value has had
+	// the * and escapes removed.
+	PrefixNode struct {
+		Field string
+		Value string
+	}
+
+	// ProximityNode for phrases followed by ~N, e.g.
+	// "gene lymphoma"~5, which match if the words are all in the
+	// value, in any order, with at most Distance other words
+	// between them. LIKE cannot measure distance so the sql only
+	// checks that the value contains each of the words, which Eval
+	// does properly since it can.
+	ProximityNode struct {
+		Field    string
+		Words    []string
+		Distance int
+	}
+)
+
+// MaxProximityDistance is the largest N of "some words"~N, which is
+// far more than any useful distance but keeps the window Eval
+// searches small
+const MaxProximityDistance = 1000
+
+// Parses a quoted string at the current position returning the
+// value between the quotes with escapes removed
+func (p *Parser) parseQuoted() (string, error) {
+	quote := p.pos
+
+	// consume opening quote
+	p.next()
+
+	start := p.pos
+
+	for {
+		// we want to keep all chars until we see the closing quote
+		// or the end of the input
+		if p.peek() == '"' || p.peek() == 0 {
+			break
+		}
+
+		// \" and \\ let quotes and backslashes be searched for
+		if p.next() == EscapeChar && p.peek() != 0 {
+			p.next()
+		}
+	}
+
+	if p.peek() != '"' {
+		return "", p.errorAt(quote, errors.New("unterminated quoted variable"), `"`)
+	}
+
+	// quoted values are literals
+	value := unescape(p.input[start:p.pos])
+
+	// consume closing quote
+	p.next()
+
+	return value, nil
+}
+
+// Parses "a phrase" or "some words"~N
+func (p *Parser) parsePhrase(field string) (Node, error) {
+	start := p.pos
+
+	value, err := p.parseQuoted()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(value) == "" {
+		return nil, p.errorAt(start, errors.New("empty search term"))
+	}
+
+	if p.peek() != '~' {
+		return &PhraseNode{Field: field, Value: value}, nil
+	}
+
+	p.next()
+
+	digits := p.pos
+
+	for p.peek() >= '0' && p.peek() <= '9' {
+		p.next()
+	}
+
+	distance, err := strconv.Atoi(p.input[digits:p.pos])
+
+	if err != nil && p.pos == digits {
+		return nil, p.errorAt(digits, errors.New("expected distance after ~"), "number")
+	}
+
+	if err != nil || distance > MaxProximityDistance {
+		return nil, p.errorfAt(digits, "distance must be at most %d", nil, MaxProximityDistance)
+	}
+
+	return &ProximityNode{Field: field, Words: strings.Fields(value), Distance: distance}, nil
+}
+
+// Returns the literal prefix of a canonical glob value if its only
+// wildcard is a trailing *
+func prefixValue(value string) (string, bool) {
+	if len(value) < 2 || !endsWithUnescaped(value, '*') {
+		return "", false
+	}
+
+	value = value[:len(value)-1]
+
+	if containsUnescaped(value, '*') || containsUnescaped(value, '?') {
+		return "", false
+	}
+
+	return unescape(value), true
+}
+
+func (n *PhraseNode) likePattern() string {
+	return "%" + EscapeLike(n.Value) + "%"
+}
+
+func (n *PrefixNode) likePattern() string {
+	return EscapeLike(n.Value) + "%"
+}
+
+func (n *PhraseNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	return b.termClause(n.Field, n.likePattern(), addParens)
+}
+
+func (n *PrefixNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	return b.termClause(n.Field, n.likePattern(), addParens)
+}
+
+// The value must contain each word, which is the closest LIKE can
+// get to proximity
+func (n *ProximityNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	clauses := make([]string, 0, len(n.Words))
+
+	for _, w := range n.Words {
+		clauses = append(clauses, b.termClause(n.Field, "%"+EscapeLike(w)+"%", true))
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+
+	return AddParens(strings.Join(clauses, " AND "), addParens)
+}
+
+func (n *PhraseNode) Eval(match Matcher) bool {
+	return matchAny(match(n.Field), n.likePattern())
+}
+
+func (n *PrefixNode) Eval(match Matcher) bool {
+	return matchAny(match(n.Field), n.likePattern())
+}
+
+func (n *ProximityNode) Eval(match Matcher) bool {
+	for _, v := range match(n.Field) {
+		if isNear(words(v), n.Words, n.Distance) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *PhraseNode) String() string {
+	return fieldPrefix(n.Field) + quote(n.Value)
+}
+
+func (n *PrefixNode) String() string {
+	return fieldPrefix(n.Field) + renderGlob(EscapeTerm(n.Value)) + "*"
+}
+
+func (n *ProximityNode) String() string {
+	return fieldPrefix(n.Field) + quote(strings.Join(n.Words, " ")) + "~" + strconv.Itoa(n.Distance)
+}
+
+func fieldPrefix(field string) string {
+	if field == "" {
+		return ""
+	}
+
+	return field + ":"
+}
+
+// Quotes s escaping quotes and backslashes
+func quote(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for _, c := range s {
+		if c == '"' || c == EscapeChar {
+			b.WriteRune(EscapeChar)
+		}
+
+		b.WriteRune(c)
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// Splits a value into lowercase words of letters and digits
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// Reports whether all the query words are in a run of tokens
+// with at most distance other tokens in it
+func isNear(tokens []string, query []string, distance int) bool {
+	want := make([]string, 0, len(query))
+
+	for _, q := range query {
+		want = append(want, words(q)...)
+	}
+
+	if len(want) == 0 {
+		return false
+	}
+
+	// the window must hold every query word plus at most distance
+	// others, which can be no more than there are tokens
+	size := len(want) + min(distance, len(tokens))
+
+	for start := range tokens {
+		end := min(start+size, len(tokens))
+
+		if containsAll(tokens[start:end], want) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Each query word must match a different token so
+// repeated words must appear as often as asked for
+func containsAll(tokens []string, want []string) bool {
+	tokens = slices.Clone(tokens)
+
+	for _, w := range want {
+		i := slices.Index(tokens, w)
+
+		if i == -1 {
+			return false
+		}
+
+		tokens[i] = ""
+	}
+
+	return true
+}
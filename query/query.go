@@ -2,13 +2,37 @@ package query
 
 import (
 	"strings"
+	"unicode"
 
 	"github.com/antonybholmes/go-sys"
+	"golang.org/x/text/unicode/norm"
 )
 
+// CharPolicy reports whether a char may appear in a query
+type CharPolicy func(c rune) bool
+
+// the chars with a meaning in the query syntax
+const syntaxChars = ` _,+="^$().-:?*%!<>\~`
+
 var (
 	// O(1) lookup for allowed chars to strip out invalid chars from queries
 	allowedChar [256]bool
+
+	// UnicodeChars allows letters, combining marks and digits in
+	// any script, e.g. TNF-α, plus the chars of the query syntax.
+	// It is the default policy.
+	UnicodeChars CharPolicy = func(c rune) bool {
+		return unicode.IsLetter(c) ||
+			unicode.IsMark(c) ||
+			unicode.IsDigit(c) ||
+			strings.ContainsRune(syntaxChars, c)
+	}
+
+	// ASCIIChars only allows ASCII letters and digits plus the
+	// chars of the query syntax
+	ASCIIChars CharPolicy = func(c rune) bool {
+		return c < 0x80 && allowedChar[c]
+	}
 )
 
 func init() {
@@ -26,24 +50,67 @@ func init() {
 		allowedChar[c] = true
 	}
 
-	for _, c := range []byte(" _,+=\"^$().-:?*%!") {
+	for _, c := range []byte(syntaxChars) {
 		allowedChar[c] = true
 	}
 }
 
+// WithCharPolicy sets the chars SanitizeQuery keeps
+func WithCharPolicy(policy CharPolicy) Option {
+	return func(o *options) {
+		o.charPolicy = policy
+	}
+}
+
+// WithUnicodeForm sets the Unicode normalization form SanitizeQuery
+// applies. It defaults to NFC so that, e.g. an é typed as an e and
+// a combining accent matches the single char é. NFKC also folds
+// compatibility chars such as full width letters into their plain
+// forms.
+func WithUnicodeForm(form norm.Form) Option {
+	return func(o *options) {
+		o.unicodeForm = form
+	}
+}
+
+// StripInvalid removes the chars that UnicodeChars does not allow
 func StripInvalid(s string) string {
-	b := make([]byte, 0, len(s))
-	for i := 0; i < len(s); i++ {
+	return StripInvalidFunc(s, UnicodeChars)
+}
+
+// StripInvalidFunc removes the chars that the policy does not allow.
+// Whitespace is kept as spaces.
+func StripInvalidFunc(s string, policy CharPolicy) string {
+	var b strings.Builder
 
-		if allowedChar[s[i]] {
-			b = append(b, s[i])
+	b.Grow(len(s))
+
+	for _, c := range s {
+		switch {
+		case unicode.IsSpace(c):
+			b.WriteByte(' ')
+		case policy(c):
+			b.WriteRune(c)
 		}
 	}
-	return string(b)
+
+	return b.String()
 }
 
-func SanitizeQuery(input string) string {
-	return strings.TrimSpace(sys.NormalizeSpaces(StripInvalid(input)))
+// SanitizeQuery normalizes the query, removes chars that are
+// not allowed and collapses runs of spaces
+func SanitizeQuery(input string, opts ...Option) string {
+	o := newOptions(opts)
+
+	policy := o.charPolicy
+
+	if policy == nil {
+		policy = UnicodeChars
+	}
+
+	input = o.unicodeForm.String(input)
+
+	return strings.TrimSpace(sys.NormalizeSpaces(StripInvalidFunc(input, policy)))
 }
 
 // func normalizeImplicitAnd(input string) string {
@@ -1,18 +1,41 @@
 package query
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 func TestNormalizeQuery(t *testing.T) {
-	x := normalizeQuery(`x+(y AND z) OR "a AND b"`)
-	fmt.Println(x)
-
-	x = normalizeQuery("( x+ y ) ,c")
-	fmt.Println(x)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`x+(y AND z) OR "a AND b"`, `x+(y+z),"a AND b"`},
+		{"( x+ y ) ,c", "(x+y),c"},
+		{"A (B, C)", "A+(B,C)"},
+		{"A NOT B", "A+!B"},
+		{`A "B C"`, `A+"B C"`},
+		{"score >= 1 B", "score>=1+B"},
+	}
 
-	// Test cases for normalizeQuery
+	for _, test := range tests {
+		if got := normalizeQuery(test.query); got != test.want {
+			t.Errorf("normalizeQuery(%q) = %q; want %q", test.query, got, test.want)
+		}
+	}
 }
 
 func TestAdd(t *testing.T) {
@@ -28,10 +51,1244 @@ func TestAdd(t *testing.T) {
 
 	})
 
-	//fmt.Println("A+B,=C+(D+E)")
-	fmt.Println(resp.Sql)
+	if resp.Sql != "(?1 AND ?2) OR (?3 AND (?4 AND ?5))" {
+		t.Errorf("SqlBoolQuery = %s; want %s", resp.Sql, "(?1 AND ?2) OR (?3 AND (?4 AND ?5))")
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		query  string
+		values []string
+		want   bool
+	}{
+		{"BCL", []string{"BCL6"}, true},
+		{"bcl", []string{"BCL6"}, true},
+		{"=BCL", []string{"BCL6"}, false},
+		{"^BCL6$", []string{"bcl6"}, true},
+		{"BCL*", []string{"BCL6"}, true},
+		{"*6", []string{"BCL6"}, true},
+		{"BCL?", []string{"BCL6"}, true},
+		{"BCL?", []string{"BCL"}, false},
+		{"BCL6 MYC", []string{"BCL6"}, false},
+		{"BCL6,MYC", []string{"MYC"}, true},
+		{"-MYC", []string{"BCL6"}, true},
+		{"(BCL6,MYC)+ENSG", []string{"MYC", "ENSG0001"}, true},
+	}
+
+	for _, test := range tests {
+		tree, err := SqlBoolTree(test.query)
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", test.query, err)
+		}
+
+		if got := tree.Eval(MatchValues(test.values...)); got != test.want {
+			t.Errorf("Eval(%q, %v) = %v; want %v", test.query, test.values, got, test.want)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	fields := Fields{"symbol": "gex.gene_symbol", "chr": "gex.chr"}
+
+	clause := func(placeholderIndex int, field string, value string, addParens bool) string {
+		if field == "" {
+			field = "gex.default"
+		}
+
+		return fmt.Sprintf("%s LIKE ?%d", field, placeholderIndex)
+	}
+
+	resp, err := SqlFieldBoolQuery("symbol:=BCL6 AND CHR:chr3, MYC", clause, WithFields(fields))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "(gex.gene_symbol LIKE ?1 AND gex.chr LIKE ?2) OR gex.default LIKE ?3"
+
+	if resp.Sql != want {
+		t.Errorf("SqlFieldBoolQuery = %s; want %s", resp.Sql, want)
+	}
+
+	if !slices.Equal(resp.Args, []any{"BCL6", "%chr3%", "%MYC%"}) {
+		t.Errorf("args = %v", resp.Args)
+	}
+
+	_, err = SqlFieldBoolQuery("gene:BCL6", clause, WithFields(fields))
+
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("err = %v; want ErrUnknownField", err)
+	}
+
+	// without fields : is part of the term
+	resp, err = SqlBoolQuery("chr3:1000", func(placeholderIndex int, value string, addParens bool) string {
+		return fmt.Sprintf("?%d", placeholderIndex)
+	})
+
+	if err != nil || resp.Args[0] != "%chr3:1000%" {
+		t.Errorf("SqlBoolQuery(chr3:1000) = %v, %v", resp, err)
+	}
+
+	tree, _ := SqlBoolTree(`symbol:"BCL6"`, WithFields(fields))
+
+	match := MatchFields(map[string][]string{"symbol": {"BCL6"}, "": {"MYC"}})
+
+	if !tree.Eval(match) {
+		t.Errorf("Eval(symbol:BCL6) = false; want true")
+	}
+
+	// without fields, fields from json or the builder are written
+	// into the sql so anything but a plain column is rejected
+	injected, err := UnmarshalNode([]byte(`{"type":"term","field":"1=1 OR c","value":"a","match":"contains"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tree := range []Node{injected, Compare("x) OR (1", OpGt, 1), In("c; DROP TABLE t", "a")} {
+		resp, err := NewSqlBuilder(nil, WithColumns("c")).Build(tree)
+
+		if !errors.Is(err, ErrUnknownField) {
+			t.Errorf("Build(%s) = %v, %v; want ErrUnknownField", tree, resp, err)
+		}
+	}
+
+	if _, err := NewSqlBuilder(nil).Build(Exact("a").On("gene_symbol")); err != nil {
+		t.Errorf("Build(gene_symbol:=a) error: %v", err)
+	}
+}
+
+func TestComparisons(t *testing.T) {
+	fields := Fields{"score": "s.score", "pos": "g.start"}
+
+	clause := func(placeholderIndex int, field string, value string, addParens bool) string {
+		return fmt.Sprintf("g.symbol LIKE :p%d", placeholderIndex)
+	}
+
+	resp, err := SqlFieldBoolQuery("BCL6 score >= 0.5, pos:1000..2000", clause, WithFields(fields))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "(g.symbol LIKE :p1 AND s.score >= :p2) OR (g.start >= :p3 AND g.start <= :p4)"
+
+	if resp.Sql != want {
+		t.Errorf("SqlFieldBoolQuery = %s; want %s", resp.Sql, want)
+	}
+
+	if !slices.Equal(resp.Args, []any{"%BCL6%", 0.5, int64(1000), int64(2000)}) {
+		t.Errorf("args = %v", resp.Args)
+	}
+
+	named := resp.Named()
+
+	if len(named) != 4 || named[1] != sql.Named("p2", 0.5) || !slices.Equal(resp.Positional(), resp.Args) {
+		t.Errorf("Named() = %v", named)
+	}
+
+	_, err = SqlBoolTree("score>0.5")
+
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("err = %v; want ErrUnknownField", err)
+	}
+
+	tree, err := SqlBoolTree("score<1 pos:1000..", WithFields(fields))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match := MatchFields(map[string][]string{"score": {"0.2"}, "pos": {"1500"}})
+
+	if !tree.Eval(match) {
+		t.Errorf("Eval(score<1 pos:1000..) = false; want true")
+	}
+}
+
+func TestDialects(t *testing.T) {
+	query := "BCL* symbol:=MYC"
+	fields := Fields{"symbol": "gene_symbol"}
+
+	tests := []struct {
+		dialect Dialect
+		sql     string
+		args    []any
+	}{
+		{Sqlite, `(gene_symbol LIKE :p1 ESCAPE '\' OR ensembl_id LIKE :p1 ESCAPE '\') AND gene_symbol LIKE :p2 ESCAPE '\'`, []any{"BCL%", "MYC"}},
+		{Postgres, `(gene_symbol ILIKE $1 ESCAPE '\' OR ensembl_id ILIKE $1 ESCAPE '\') AND gene_symbol ILIKE $2 ESCAPE '\'`, []any{"BCL%", "MYC"}},
+		{MySQL, `(gene_symbol LIKE ? ESCAPE '\\' OR ensembl_id LIKE ? ESCAPE '\\') AND gene_symbol LIKE ? ESCAPE '\\'`, []any{"BCL%", "BCL%", "MYC"}},
+	}
+
+	for _, test := range tests {
+		resp, err := SqlBoolQuery(query, nil, WithDialect(test.dialect), WithFields(fields), WithColumns("gene_symbol", "ensembl_id"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Sql != test.sql {
+			t.Errorf("%s: sql = %s; want %s", test.dialect.Name(), resp.Sql, test.sql)
+		}
+
+		if !slices.Equal(resp.Args, test.args) {
+			t.Errorf("%s: args = %v; want %v", test.dialect.Name(), resp.Args, test.args)
+		}
+	}
+
+	d, err := DialectFor("postgres")
+
+	if err != nil || d != Postgres {
+		t.Errorf("DialectFor(postgres) = %v, %v", d, err)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	tests := []struct {
+		query   string
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		// contains
+		{`HLA_A`, `%HLA\_A%`, []string{"HLA_A", "xHLA_Ax"}, []string{"HLAXA"}},
+		{`50%`, `%50\%%`, []string{"50%"}, []string{"500"}},
+		{`BCL?`, `%BCL_%`, []string{"BCL6", "BCL66"}, []string{"BCL"}},
+		// patterns
+		{`BCL*`, `BCL%`, []string{"BCL6", "BCL"}, []string{"xBCL6"}},
+		{`*_A`, `%\_A`, []string{"HLA_A"}, []string{"HLAXA"}},
+		{`A\*B*`, `A*B%`, []string{"A*BC"}, []string{"AXBC"}},
+		// contains with escaped wildcards
+		{`A\?`, `%A?%`, []string{"A?"}, []string{"AB"}},
+		{`C:\\temp`, `%C:\\temp%`, []string{`C:\temp`}, []string{"C:temp"}},
+		// exact
+		{`=HLA_A`, `HLA\_A`, []string{"hla_a"}, []string{"HLAXA", "HLA_AB"}},
+		{`^HLA_A$`, `HLA\_A`, []string{"HLA_A"}, []string{"HLAXA"}},
+		{`^A\$$`, `A$`, []string{"A$"}, []string{"A"}},
+		// phrases
+		{`"A*B"`, `%A*B%`, []string{"A*B", "xa*by"}, []string{"AXB"}},
+		{`"say \"hi\""`, `%say "hi"%`, []string{`they say "hi"`}, []string{"say hi"}},
+		{`="A B"`, `A B`, []string{"a b"}, []string{"A B C"}},
+	}
+
+	for _, test := range tests {
+		resp, err := SqlBoolQuery(test.query, nil, WithColumns("c"))
+
+		if err != nil {
+			t.Fatalf("SqlBoolQuery(%q) error: %v", test.query, err)
+		}
+
+		if len(resp.Args) != 1 || resp.Args[0] != test.pattern {
+			t.Errorf("SqlBoolQuery(%q) args = %v; want %q", test.query, resp.Args, test.pattern)
+		}
+
+		tree, _ := SqlBoolTree(test.query)
+
+		for _, v := range test.match {
+			if !tree.Eval(MatchValues(v)) {
+				t.Errorf("Eval(%q, %q) = false; want true", test.query, v)
+			}
+		}
+
+		for _, v := range test.noMatch {
+			if tree.Eval(MatchValues(v)) {
+				t.Errorf("Eval(%q, %q) = true; want false", test.query, v)
+			}
+		}
+	}
+
+	if _, err := SqlBoolTree("=BCL*"); err == nil {
+		t.Errorf("SqlBoolTree(=BCL*) should fail")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	fields := Fields{"gene": "gene_symbol", "score": "score"}
+
+	tests := []struct {
+		query  string
+		offset int
+		token  string
+	}{
+		{"A AND (B", 8, ""},
+		{`BCL6 AND "x`, 9, `"`},
+		{"A, B)", 4, ")"},
+		{"A AND  organ:liver", 7, "organ:liver"},
+		{"score > abc", 8, "abc"},
+		{"AB AND =BC*", 7, "=BC*"},
+		{"é AND =BC*", 7, "=BC*"},
+	}
+
+	for _, test := range tests {
+		_, err := SqlBoolTree(test.query, WithFields(fields))
+
+		var perr *ParseError
+
+		if !errors.As(err, &perr) {
+			t.Fatalf("SqlBoolTree(%q) error = %v; want *ParseError", test.query, err)
+		}
+
+		if perr.Offset != test.offset || perr.Token != test.token {
+			t.Errorf("SqlBoolTree(%q) offset, token = %d, %q; want %d, %q", test.query, perr.Offset, perr.Token, test.offset, test.token)
+		}
+	}
+
+	_, err := SqlBoolTree("A AND  organ:liver", WithFields(fields))
+
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("unknown field error %v should wrap ErrUnknownField", err)
+	}
+
+	var perr *ParseError
+
+	errors.As(err, &perr)
+
+	if want := "A AND  organ:liver\n       ^"; perr.Snippet() != want {
+		t.Errorf("Snippet() = %q; want %q", perr.Snippet(), want)
+	}
+}
+
+// Generates random trees of the shapes the parser creates
+func randomTree(r *rand.Rand, depth int) Node {
+	if depth == 0 || r.IntN(3) == 0 {
+		switch r.IntN(6) {
+		case 0:
+			ops := []CompareOp{OpGt, OpGe, OpLt, OpLe}
+			return &ComparisonNode{Field: "score", Op: ops[r.IntN(len(ops))], Value: float64(r.IntN(200)-100) / 4}
+		case 1:
+			min := float64(r.IntN(100) - 50)
+			return &RangeNode{Field: "pos", Min: min, Max: min + float64(r.IntN(100))/2}
+		default:
+			return randomTerm(r)
+		}
+	}
+
+	switch r.IntN(3) {
+	case 0:
+		return &NotNode{Child: randomTree(r, depth-1)}
+	case 1:
+		return &AndNode{Left: randomTree(r, depth-1), Right: randomTree(r, depth-1)}
+	default:
+		return &OrNode{Left: randomTree(r, depth-1), Right: randomTree(r, depth-1)}
+	}
+}
+
+func randomTerm(r *rand.Rand) Node {
+	chars := []string{"a", "B", "7", "-", "_", ".", ":", "%", "?", "=", "^", "$", "!", "+", ",", "(", ")", "<", `"`, "AND", "OR", " ", `\*`, `\?`, `\\`, "α", "é", "—", "基因"}
+
+	var value string
+
+	for range 1 + r.IntN(6) {
+		value += chars[r.IntN(len(chars))]
+	}
+
+	term := &SearchTermNode{Value: value, MatchType: MatchTypeContains}
+
+	if r.IntN(3) == 0 {
+		term.Field = "gene"
+	}
+
+	switch r.IntN(5) {
+	case 0:
+		term.Value = unescape(value)
+		term.MatchType = MatchTypeExact
+	case 1:
+		term.Value += "*"
+		term.MatchType = MatchTypePattern
+
+		if prefix, ok := prefixValue(term.Value); ok {
+			return &PrefixNode{Field: term.Field, Value: prefix}
+		}
+	case 2:
+		if strings.TrimSpace(unescape(value)) != "" {
+			return &PhraseNode{Field: term.Field, Value: unescape(value)}
+		}
+	case 3:
+		if words := strings.Fields(unescape(value)); len(words) > 0 {
+			return &ProximityNode{Field: term.Field, Words: words, Distance: r.IntN(10)}
+		}
+	}
+
+	return term
+}
+
+func TestRoundTrip(t *testing.T) {
+	fields := Fields{"gene": "gene_symbol", "score": "score", "pos": "position"}
+
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for range 2000 {
+		tree := randomTree(r, 4)
+
+		query := tree.String()
+
+		parsed, err := SqlBoolTree(query, WithFields(fields))
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", query, err)
+		}
+
+		if !reflect.DeepEqual(parsed, tree) {
+			t.Fatalf("SqlBoolTree(%q) = %s; want %s", query, parsed, tree)
+		}
+
+		data, err := json.Marshal(tree)
+
+		if err != nil {
+			t.Fatalf("json.Marshal(%s) error: %v", query, err)
+		}
+
+		decoded, err := UnmarshalNode(data)
+
+		if err != nil {
+			t.Fatalf("UnmarshalNode(%s) error: %v", data, err)
+		}
+
+		if !reflect.DeepEqual(decoded, tree) {
+			t.Fatalf("UnmarshalNode(%s) = %s; want %s", data, decoded, query)
+		}
+	}
+
+	tree, _ := SqlBoolTree(`-gene:=TP53 (BCL6 OR MYC) "A B"`, WithFields(fields))
+
+	if want := `-gene:="TP53"+(BCL6,MYC)+"A B"`; tree.String() != want {
+		t.Errorf("String() = %s; want %s", tree, want)
+	}
+
+	if _, err := UnmarshalNode([]byte(`{"type":"and","left":{"type":"term","value":"A","match":"contains"}}`)); err == nil {
+		t.Errorf("UnmarshalNode should fail without a right node")
+	}
+
+	// comparisons need a valid op and a field, also as param targets
+	for _, data := range []string{
+		`{"type":"compare","field":"score","value":1}`,
+		`{"type":"compare","field":"score","op":"","value":1}`,
+		`{"type":"compare","field":"score","op":"=","value":1}`,
+		`{"type":"compare","op":">","value":1}`,
+		`{"type":"param","name":"min","target":{"type":"compare","field":"score"}}`,
+		`{"type":"param","name":"min","target":{"type":"compare","op":">"}}`,
+	} {
+		if node, err := UnmarshalNode([]byte(data)); err == nil {
+			t.Errorf("UnmarshalNode(%s) = %s; want an error", data, node)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		query string
+		opts  []Option
+		want  string
+	}{
+		{"A+A", nil, "A"},
+		{"(A,B),A", nil, "A,B"},
+		{"-(-A)", nil, "A"},
+		{"A+(B+(C+A))", nil, "A+B+C"},
+		{"A+(A,B)", nil, "A"},
+		{"A,(A+B)", nil, "A"},
+		{"(A,B)+(B,A,C)", nil, "A,B"},
+		{"A+(B,C)", []Option{WithNormalForm(NormalFormDNF)}, "A+B,A+C"},
+		{"A,(B+C)", []Option{WithNormalForm(NormalFormCNF)}, "(A,B)+(A,C)"},
+		{"-(A,B)", []Option{WithNormalForm(NormalFormDNF)}, "-A+-B"},
+		{"A+B,C+D", []Option{WithNormalForm(NormalFormCNF), WithMaxClauses(4)}, "(A,C)+(A,D)+(B,C)+(B,D)"},
+		{"A+B,C+D", []Option{WithNormalForm(NormalFormCNF), WithMaxClauses(3)}, "A+B,C+D"},
+		{"(A,B)+(C,D),A+-A", []Option{WithNormalForm(NormalFormDNF), WithMaxClauses(4)}, "(A,B)+(C,D),A+-A"},
+		{"=A,B,=C,=A", []Option{WithInLists()}, `="A",="C",B`},
+		{"(=A,=B)+C", []Option{WithInLists()}, `(="A",="B")+C`},
+		{"=bcl6,=MYC", []Option{WithInLists()}, `="bcl6",="MYC"`},
+	}
+
+	for _, test := range tests {
+		tree, err := SqlBoolTree(test.query)
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", test.query, err)
+		}
+
+		simple := Simplify(tree, test.opts...)
+
+		if simple.String() != test.want {
+			t.Errorf("Simplify(%q) = %s; want %s", test.query, simple, test.want)
+		}
+
+		// simplifying must not change what matches
+		for _, v := range []string{"A", "a", "B", "C", "AB", "aBc", "D", "BCL6", "myc"} {
+			if simple.Eval(MatchValues(v)) != tree.Eval(MatchValues(v)) {
+				t.Errorf("Simplify(%q).Eval(%q) differs from the tree", test.query, v)
+			}
+		}
+	}
+
+	// normal forms that would be too big are not created at all
+	// rather than being thrown away afterwards
+	pairs := make([]string, 20)
+
+	for i := range pairs {
+		pairs[i] = fmt.Sprintf("(a%d+b%d)", i, i)
+	}
+
+	tree, _ := SqlBoolTree(strings.Join(pairs, ","))
+
+	if simple := Simplify(tree, WithNormalForm(NormalFormCNF)); simple.String() != Simplify(tree).String() {
+		t.Errorf("Simplify(CNF of %d pairs) = %s; want it unchanged", len(pairs), simple)
+	}
+
+	tree, _ = SqlBoolTree("=A,B,=C,D")
+
+	resp, err := NewSqlBuilder(nil, WithColumns("c")).Build(Simplify(tree, WithInLists()))
+
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	if want := `LOWER(c) IN (:p1, :p2) OR c LIKE :p3 ESCAPE '\' OR c LIKE :p4 ESCAPE '\'`; resp.Sql != want {
+		t.Errorf("Build() = %s; want %s", resp.Sql, want)
+	}
+
+	// IN ignores case like the exact terms it replaces
+	tree, _ = SqlBoolTree("=bcl6,=MYC")
+
+	resp, err = NewSqlBuilder(nil, WithDialect(Postgres), WithColumns("c")).Build(Simplify(tree, WithInLists()))
+
+	if err != nil || resp.Sql != "LOWER(c) IN ($1, $2)" || !slices.Equal(resp.Args, []any{"bcl6", "myc"}) {
+		t.Errorf("Build() = %v, %v; want LOWER(c) IN ($1, $2) [bcl6 myc]", resp, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		query  string
+		opt    Option
+		err    error
+		offset int
+	}{
+		{"BCL6 MYC", WithMaxLength(6), ErrTooLong, 6},
+		{"A B C D", WithMaxTerms(3), ErrTooManyTerms, 6},
+		{"A+((B,C))", WithMaxDepth(1), ErrTooDeep, 3},
+		{"--!A", WithMaxDepth(2), ErrTooDeep, 2},
+		{"-(A,-B)", WithMaxDepth(2), ErrTooDeep, 4},
+		{"*A *B", WithMaxLeadingWildcards(1), ErrLeadingWildcards, 3},
+		{"?A", WithMaxLeadingWildcards(0), ErrLeadingWildcards, 0},
+	}
+
+	for _, test := range tests {
+		_, err := SqlBoolQuery(test.query, nil, WithColumns("c"), test.opt)
+
+		var lerr *LimitError
+		var perr *ParseError
+
+		if !errors.Is(err, test.err) || !errors.As(err, &lerr) || !errors.As(err, &perr) {
+			t.Fatalf("SqlBoolQuery(%q) error = %v; want %v", test.query, err, test.err)
+		}
+
+		if perr.Offset != test.offset {
+			t.Errorf("SqlBoolQuery(%q) offset = %d; want %d", test.query, perr.Offset, test.offset)
+		}
+	}
+
+	// within the limits, and escaped or exact wildcards do not count
+	_, err := SqlBoolTree(`A* \*B "*C"`, WithMaxLength(11), WithMaxTerms(3), WithMaxLeadingWildcards(0))
+
+	if err != nil {
+		t.Errorf("SqlBoolTree error: %v", err)
+	}
+
+	// deep nesting fails by default rather than exhausting the stack
+	deep := strings.Repeat("(", 100000) + "A" + strings.Repeat(")", 100000)
+
+	if _, err := SqlBoolTree(deep); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("SqlBoolTree(deep) error = %v; want %v", err, ErrTooDeep)
+	}
+
+	// oversized queries are rejected before they are normalized,
+	// which copies them several times
+	huge := strings.Repeat("A ", 1<<20)
+
+	var before, after runtime.MemStats
+
+	runtime.ReadMemStats(&before)
+
+	_, err = SqlBoolTree(huge, WithMaxLength(100))
+
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, ErrTooLong) || after.TotalAlloc-before.TotalAlloc > uint64(len(huge)) {
+		t.Errorf("SqlBoolTree(huge) = %v after allocating %d bytes", err, after.TotalAlloc-before.TotalAlloc)
+	}
+
+	// as do long runs of NOTs
+	for _, not := range []string{"-", "!", "NOT "} {
+		deep = strings.Repeat(not, 100000) + "A"
+
+		if _, err := SqlBoolTree(deep, WithMaxDepth(10)); !errors.Is(err, ErrTooDeep) {
+			t.Errorf("SqlBoolTree(%s...A) error = %v; want %v", not, err, ErrTooDeep)
+		}
+	}
+}
+
+func TestFullText(t *testing.T) {
+	tests := []struct {
+		query string
+		fts5  string
+		ts    string
+	}{
+		{"BCL6", `"BCL6"`, `'BCL6'`},
+		{"BCL* MYC", `"BCL"* AND "MYC"`, `'BCL':* & 'MYC'`},
+		{`"B cell" OR T`, `"B cell" OR "T"`, `('B' <-> 'cell') | 'T'`},
+		{`-B A (C,D)`, `("A" AND ("C" OR "D")) NOT "B"`, `!'B' & 'A' & ('C' | 'D')`},
+		{`"it's" AND`, `"it's" AND "AND"`, `'it''s' & 'AND'`},
+		{`"say \"hi\""`, `"say ""hi"""`, `('say' <-> '"hi"')`},
+	}
+
+	for _, test := range tests {
+		// \AND is the term AND rather than the operator
+		tree, err := SqlBoolTree(strings.Replace(test.query, " AND", ` \AND`, 1))
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", test.query, err)
+		}
+
+		if fts5, err := Fts5Query(tree); err != nil || fts5 != test.fts5 {
+			t.Errorf("Fts5Query(%q) = %s, %v; want %s", test.query, fts5, err, test.fts5)
+		}
+
+		if ts, err := TsQuery(tree); err != nil || ts != test.ts {
+			t.Errorf("TsQuery(%q) = %s, %v; want %s", test.query, ts, err, test.ts)
+		}
+	}
+
+	fields := Fields{"gene": "gene_symbol", "score": "score"}
+
+	tree, _ := SqlBoolTree("gene:BCL6", WithFields(fields))
+
+	if fts5, _ := Fts5Query(tree, WithFields(fields)); fts5 != `gene_symbol : "BCL6"` {
+		t.Errorf("Fts5Query(gene:BCL6) = %s", fts5)
+	}
+
+	for _, query := range []string{"*BCL", "B?L6", "-A", "A,-B", "score>1"} {
+		tree, _ := SqlBoolTree(query, WithFields(fields))
+
+		if _, err := Fts5Query(tree, WithFields(fields)); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Fts5Query(%q) error = %v; want %v", query, err, ErrUnsupported)
+		}
+	}
+}
+
+func TestElasticQuery(t *testing.T) {
+	fields := Fields{"": "symbol", "gene": "symbol", "score": "score"}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"=BCL6", `{"term":{"symbol":{"case_insensitive":true,"value":"BCL6"}}}`},
+		{"BCL", `{"wildcard":{"symbol":{"case_insensitive":true,"value":"*BCL*"}}}`},
+		{`"B cell"`, `{"match_phrase":{"symbol":{"query":"B cell"}}}`},
+		{`-B BCL\*6*`, `{"bool":{"must":[{"prefix":{"symbol":{"case_insensitive":true,"value":"BCL*6"}}}],"must_not":[{"wildcard":{"symbol":{"case_insensitive":true,"value":"*B*"}}}]}}`},
+		{"A,score>=1.5", `{"bool":{"minimum_should_match":1,"should":[{"wildcard":{"symbol":{"case_insensitive":true,"value":"*A*"}}},{"range":{"score":{"gte":1.5}}}]}}`},
+		{"score:1..2", `{"range":{"score":{"gte":1,"lte":2}}}`},
+	}
+
+	for _, test := range tests {
+		tree, err := SqlBoolTree(test.query, WithFields(fields))
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", test.query, err)
+		}
+
+		q, err := ElasticQuery(tree, WithFields(fields))
+
+		if err != nil {
+			t.Fatalf("ElasticQuery(%q) error: %v", test.query, err)
+		}
+
+		data, _ := json.Marshal(q)
+
+		if string(data) != test.want {
+			t.Errorf("ElasticQuery(%q) = %s; want %s", test.query, data, test.want)
+		}
+	}
+
+	// plain terms search each of the columns
+	tree, _ := SqlBoolTree("-A")
+
+	q, _ := ElasticQuery(tree, WithColumns("symbol", "name"))
+
+	data, _ := json.Marshal(q)
+
+	if want := `{"bool":{"must_not":[{"bool":{"minimum_should_match":1,"should":[{"wildcard":{"symbol":{"case_insensitive":true,"value":"*A*"}}},{"wildcard":{"name":{"case_insensitive":true,"value":"*A*"}}}]}}]}}`; string(data) != want {
+		t.Errorf("ElasticQuery(-A) = %s; want %s", data, want)
+	}
+}
+
+func TestPhrases(t *testing.T) {
+	tests := []struct {
+		query   string
+		sql     string
+		args    []any
+		match   []string
+		noMatch []string
+	}{
+		{`"B cell"`, `c LIKE :p1 ESCAPE '\'`, []any{"%B cell%"}, []string{"activated b cell lymphoma"}, []string{"B-cell"}},
+		{"BCL*", `c LIKE :p1 ESCAPE '\'`, []any{"BCL%"}, []string{"bcl6"}, []string{"XBCL6"}},
+		{`"gene lymphoma"~2`, `c LIKE :p1 ESCAPE '\' AND c LIKE :p2 ESCAPE '\'`, []any{"%gene%", "%lymphoma%"},
+			[]string{"lymphoma gene", "gene in cell lymphoma"}, []string{"gene in activated B cell lymphoma", "gene"}},
+	}
+
+	for _, test := range tests {
+		tree, err := SqlBoolTree(test.query)
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", test.query, err)
+		}
+
+		resp, err := NewSqlBuilder(nil, WithColumns("c")).Build(tree)
+
+		if err != nil || resp.Sql != test.sql || !slices.Equal(resp.Args, test.args) {
+			t.Errorf("Build(%q) = %v, %v; want %s %v", test.query, resp, err, test.sql, test.args)
+		}
+
+		for _, v := range test.match {
+			if !tree.Eval(MatchValues(v)) {
+				t.Errorf("Eval(%q, %q) = false; want true", test.query, v)
+			}
+		}
+
+		for _, v := range test.noMatch {
+			if tree.Eval(MatchValues(v)) {
+				t.Errorf("Eval(%q, %q) = true; want false", test.query, v)
+			}
+		}
+	}
+
+	tree, _ := SqlBoolTree(`"B cell" BCL* "gene lymphoma"~5`)
+
+	fts5, _ := Fts5Query(tree)
+
+	if want := `"B cell" AND "BCL"* AND NEAR("gene" "lymphoma", 5)`; fts5 != want {
+		t.Errorf("Fts5Query() = %s; want %s", fts5, want)
+	}
+
+	ts, _ := TsQuery(tree)
+
+	if want := `('B' <-> 'cell') & 'BCL':* & ('gene' & 'lymphoma')`; ts != want {
+		t.Errorf("TsQuery() = %s; want %s", ts, want)
+	}
+
+	if _, err := SqlBoolTree(`"A B"~`); err == nil {
+		t.Errorf(`SqlBoolTree("A B"~) should fail`)
+	}
+
+	// huge distances are rejected rather than overflowing
+	for _, query := range []string{`"a b"~1001`, `"a b"~9223372036854775807`, `"a b"~99999999999999999999`} {
+		if _, err := SqlBoolTree(query); err == nil {
+			t.Errorf("SqlBoolTree(%s) should fail", query)
+		}
+	}
+
+	if _, err := UnmarshalNode([]byte(`{"type":"proximity","words":["a","b"],"distance":9223372036854775807}`)); err == nil {
+		t.Errorf("UnmarshalNode should reject a huge distance")
+	}
+
+	// trees from the builder are not checked so Eval must cope
+	near := Near(math.MaxInt, "a", "b")
+
+	if !near.Eval(MatchValues("a x b")) || near.Eval(MatchValues("a x")) {
+		t.Errorf("Eval(%s) is wrong", near)
+	}
+
+	if spans := Spans(near, "", "a x b"); len(spans) != 2 {
+		t.Errorf("Spans(%s) = %v", near, spans)
+	}
+}
+
+func TestNot(t *testing.T) {
+	tests := []struct {
+		query string
+		opts  []Option
+		want  string
+	}{
+		{"A -B", nil, "A+-B"},
+		{"A !B", nil, "A+-B"},
+		{"A NOT B", nil, "A+-B"},
+		{"NOT A B", nil, "-A+B"},
+		{"A AND NOT (B, C)", nil, "A+-(B,C)"},
+		{"A, NOT B C", nil, "A,-B+C"},
+		{"--A", nil, "-(-A)"},
+		{"OR4F5 BCL6-AND", nil, "OR4F5+BCL6-AND"},
+		{"a and not b", nil, `a+\and+\not+b`},
+		{"a and not b or c", []Option{WithCaseInsensitiveKeywords()}, "a+-b,c"},
+		{`"A NOT B"`, nil, `"A NOT B"`},
+	}
+
+	for _, test := range tests {
+		tree, err := SqlBoolTree(test.query, test.opts...)
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", test.query, err)
+		}
+
+		if tree.String() != test.want {
+			t.Errorf("SqlBoolTree(%q) = %s; want %s", test.query, tree, test.want)
+		}
+	}
+
+	tree, _ := SqlBoolTree("BCL6 -MYC")
+
+	if !tree.Eval(MatchValues("BCL6")) || tree.Eval(MatchValues("BCL6", "MYC")) {
+		t.Errorf("BCL6 -MYC should match BCL6 without MYC")
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	tests := []struct {
+		input string
+		opts  []Option
+		want  string
+	}{
+		{"TNF-α  AND\tcafé", nil, "TNF-α AND café"},
+		{"cafe\u0301", nil, "café"},
+		{"基因 <script>", nil, "基因 <script>"},
+		{"ＢＣＬ６ ©", nil, "ＢＣＬ６"},
+		{"ＢＣＬ６", []Option{WithUnicodeForm(norm.NFKC)}, "BCL6"},
+		{"TNF-α", []Option{WithCharPolicy(ASCIIChars)}, "TNF-"},
+	}
+
+	for _, test := range tests {
+		if got := SanitizeQuery(test.input, test.opts...); got != test.want {
+			t.Errorf("SanitizeQuery(%q) = %q; want %q", test.input, got, test.want)
+		}
+	}
+
+	tree, err := SqlBoolTree(SanitizeQuery("TNF-α OR 基因 NOT café"))
+
+	if err != nil {
+		t.Fatalf("SqlBoolTree error: %v", err)
+	}
+
+	if want := "TNF-α,基因+-café"; tree.String() != want {
+		t.Errorf("SqlBoolTree() = %s; want %s", tree, want)
+	}
+
+	if !tree.Eval(MatchValues("tnf-Α")) || !tree.Eval(MatchValues("基因")) || tree.Eval(MatchValues("基因", "CAFÉ")) {
+		t.Errorf("Eval does not match unicode values")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	tree := And(
+		Or(Exact("BCL6").On("gene"), Prefix("MYC").On("gene")),
+		Not(Term("a*b")),
+		nil,
+		Compare("score", OpGe, 0.5),
+	)
+
+	if want := `(gene:="BCL6",gene:MYC*)+-a\*b+score>=0.5`; tree.String() != want {
+		t.Errorf("String() = %s; want %s", tree, want)
+	}
+
+	fields := Fields{"gene": "gene_symbol", "score": "score", "tenant": "tenant_id"}
+
+	// the builder creates the same trees as the parser
+	parsed, _ := SqlBoolTree(tree.String(), WithFields(fields))
+
+	if !reflect.DeepEqual(parsed, tree) {
+		t.Errorf("SqlBoolTree(%s) = %s; want the built tree", tree, parsed)
+	}
+
+	if And(nil, nil) != nil {
+		t.Errorf("And(nil, nil) should be nil")
+	}
+
+	// a user query cannot escape the constraint
+	user, _ := SqlBoolTree("A OR B", WithFields(fields))
+
+	constrained := Constrain(user, Exact("1").On("tenant"))
+
+	resp, err := NewSqlBuilder(nil, WithFields(fields), WithColumns("name")).Build(constrained)
+
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	if want := `tenant_id LIKE :p1 ESCAPE '\' AND (name LIKE :p2 ESCAPE '\' OR name LIKE :p3 ESCAPE '\')`; resp.Sql != want {
+		t.Errorf("Build() = %s; want %s", resp.Sql, want)
+	}
+
+	if constrained.Eval(MatchFields(map[string][]string{"": {"B"}, "tenant": {"2"}})) {
+		t.Errorf("constrained tree should not match another tenant")
+	}
+
+	if Constrain(nil, Exact("1").On("tenant")).String() != `tenant:="1"` {
+		t.Errorf("Constrain(nil) should be the constraint")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		query string
+		text  string
+		want  string
+	}{
+		{"bcl", "BCL6 and bcl2", "<mark>BCL</mark>6 and <mark>bcl</mark>2"},
+		{"B?L", "BCL6 & BXL", "<mark>BCL</mark>6 &amp; <mark>BXL</mark>"},
+		{"=bcl6", "BCL6", "<mark>BCL6</mark>"},
+		{"=bcl", "BCL6", "BCL6"},
+		{"TNF*", "tnf-α", "<mark>tnf</mark>-α"},
+		{"B*6", "BCL6", "<mark>BCL6</mark>"},
+		{`"B cell" -lymphoma`, "a b cell lymphoma", "a <mark>b cell</mark> lymphoma"},
+		{`"gene lymphoma"~1`, "Gene of lymphoma", "<mark>Gene</mark> of <mark>lymphoma</mark>"},
+		{"cell, ell", "cells", "<mark>cell</mark>s"},
+		{`"<b>"`, "<b>x</b>", "<mark>&lt;b&gt;</mark>x&lt;/b&gt;"},
+	}
+
+	h := NewHighlighter()
+
+	for _, test := range tests {
+		tree, err := SqlBoolTree(test.query)
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) error: %v", test.query, err)
+		}
+
+		if got := h.HighlightTree(tree, "", test.text); got != test.want {
+			t.Errorf("HighlightTree(%q, %q) = %s; want %s", test.query, test.text, got, test.want)
+		}
+	}
+
+	fields := Fields{"gene": "symbol", "desc": "description"}
+
+	tree, _ := SqlBoolTree("gene:BCL desc:cell", WithFields(fields))
+
+	if spans := Spans(tree, "desc", "BCL cell"); !slices.Equal(spans, []Span{{4, 8}}) {
+		t.Errorf("Spans() = %v; want only the desc term", spans)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	fields := Fields{"symbol": "gene_symbol", "score": "score"}
+
+	tokens := Tokenize(`symbol:BCL* AND (score>=-1, "B cell"~2) -MYC #`, WithFields(fields))
+
+	want := []Token{
+		{TokenTypeField, "symbol:", 0, 7},
+		{TokenTypeTerm, "BCL*", 7, 11},
+		{TokenTypeOperator, "AND", 12, 15},
+		{TokenTypeParen, "(", 16, 17},
+		{TokenTypeField, "score", 17, 22},
+		{TokenTypeOperator, ">=", 22, 24},
+		{TokenTypeTerm, "-1", 24, 26},
+		{TokenTypeOperator, ",", 26, 27},
+		{TokenTypeQuote, `"B cell"~2`, 28, 38},
+		{TokenTypeParen, ")", 38, 39},
+		{TokenTypeOperator, "-", 40, 41},
+		{TokenTypeTerm, "MYC", 41, 44},
+		{TokenTypeInvalid, "#", 45, 46},
+	}
+
+	if !slices.Equal(tokens, want) {
+		t.Errorf("Tokenize = %v; want %v", tokens, want)
+	}
+
+	// words in terms and lowercase words are not keywords
+	// and without fields : is part of the term
+	tokens = Tokenize(`BCL6-AND and chr3:1000 ="a \" b`)
+
+	want = []Token{
+		{TokenTypeTerm, "BCL6-AND", 0, 8},
+		{TokenTypeTerm, "and", 9, 12},
+		{TokenTypeTerm, "chr3:1000", 13, 22},
+		{TokenTypeQuote, `="a \" b`, 23, 31},
+	}
+
+	if !slices.Equal(tokens, want) {
+		t.Errorf("Tokenize = %v; want %v", tokens, want)
+	}
+
+	if tokens = Tokenize("a and b", WithCaseInsensitiveKeywords()); tokens[1].Type != TokenTypeOperator {
+		t.Errorf("Tokenize(a and b) = %v; want and as an operator", tokens)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	fields := Fields{"symbol": "gene_symbol", "score": "score", "": "gene_symbol"}
+
+	operand := []TokenType{TokenTypeTerm, TokenTypeField, TokenTypeQuote, TokenTypeParen, TokenTypeOperator}
+
+	tests := []struct {
+		input    string
+		cursor   int
+		expected []TokenType
+		fields   []string
+		prefix   string
+		start    int
+	}{
+		{"", 0, operand, []string{"score", "symbol"}, "", 0},
+		{"BCL6 S", 6, operand, []string{"score", "symbol"}, "S", 5},
+		{"BCL6 sy", 7, operand, []string{"symbol"}, "sy", 5},
+		{"BCL6", 4, operand, nil, "BCL6", 0},
+		{`"B cell"`, 8, []TokenType{TokenTypeOperator}, nil, "", 8},
+		{"(BCL6 ", 6, operand, []string{"score", "symbol"}, "", 6},
+		{`("B cell"`, 9, []TokenType{TokenTypeOperator, TokenTypeParen}, nil, "", 9},
+		{`"B ce`, 5, []TokenType{TokenTypeQuote}, nil, "", 5},
+		{"symbol:", 7, []TokenType{TokenTypeTerm, TokenTypeOperator, TokenTypeQuote}, nil, "", 7},
+		{"symbol:BC", 9, []TokenType{TokenTypeTerm, TokenTypeOperator, TokenTypeQuote}, nil, "BC", 7},
+		{"score>", 6, []TokenType{TokenTypeTerm}, nil, "", 6},
+		// only what is before the cursor matters
+		{"A OR sc MYC", 7, operand, []string{"score"}, "sc", 5},
+	}
+
+	for _, test := range tests {
+		c := Complete(test.input, test.cursor, WithFields(fields))
+
+		want := slices.Sorted(slices.Values(test.expected))
+
+		if !slices.Equal(c.Expected, want) || !slices.Equal(c.Fields, test.fields) || c.Prefix != test.prefix || c.Start != test.start {
+			t.Errorf("Complete(%q, %d) = %+v; want %v %v %q %d", test.input, test.cursor, c, want, test.fields, test.prefix, test.start)
+		}
+	}
+
+	if c := Complete("BCL6 ", 5); slices.Contains(c.Expected, TokenTypeField) || c.Fields != nil {
+		t.Errorf("Complete without fields = %+v; want no fields", c)
+	}
+}
+
+var fuzzSeeds = []string{
+	"",
+	"BCL6",
+	"A+B,=C+(D+E)",
+	`symbol:"B cell"~2 AND NOT (score>=0.5, pos:1..10)`,
+	`^HLA_A$ -MYC* "say \"hi\"" ="a b"`,
+	`((A) OR B`,
+	`a\ b\* ?? ** )(`,
+	"TNF-α, 基因 !café",
+}
+
+var fuzzFields = Fields{"": "c", "symbol": "gene_symbol", "score": "score", "pos": "position"}
+
+func FuzzNormalizeQuery(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		normalized, offsets := normalizeQueryOffsets(input, true)
+
+		if len(offsets) != len(normalized)+1 {
+			t.Fatalf("normalizeQuery(%q) has %d offsets for %d bytes", input, len(offsets), len(normalized))
+		}
+
+		for _, offset := range offsets {
+			if offset < 0 || offset > len(input) {
+				t.Fatalf("normalizeQuery(%q) offset %d is outside the input", input, offset)
+			}
+		}
+	})
+}
+
+func FuzzSqlBoolTree(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tree, err := SqlBoolTree(input, WithFields(fuzzFields))
+
+		if err != nil {
+			var parseErr *ParseError
+			var limitErr *LimitError
+
+			if !errors.As(err, &parseErr) && !errors.As(err, &limitErr) {
+				t.Fatalf("SqlBoolTree(%q) error %v is not a ParseError", input, err)
+			}
+
+			if parseErr != nil && (parseErr.Offset < 0 || parseErr.Offset > len(input)) {
+				t.Fatalf("SqlBoolTree(%q) error offset %d is outside the input", input, parseErr.Offset)
+			}
+
+			return
+		}
+
+		// whatever parses must render to a query that
+		// parses to the same tree
+		s := tree.String()
+
+		again, err := SqlBoolTree(s, WithFields(fuzzFields))
+
+		if err != nil {
+			t.Fatalf("SqlBoolTree(%q) = %s which does not parse: %v", input, s, err)
+		}
+
+		if again.String() != s {
+			t.Fatalf("SqlBoolTree(%q) = %s which parses to %s", input, s, again)
+		}
+	})
+}
+
+func FuzzSqlBoolQuery(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		for _, d := range []Dialect{Sqlite, Postgres, MySQL} {
+			resp, err := SqlBoolQuery(input, nil, WithDialect(d), WithFields(fuzzFields))
+
+			if err != nil {
+				return
+			}
+
+			if err := checkSql(d, resp); err != nil {
+				t.Fatalf("SqlBoolQuery(%q) = %s %v: %v", input, resp.Sql, resp.Args, err)
+			}
+		}
+	})
+}
+
+// Checks that sql has balanced parens outside of string literals
+// and that its placeholders and args agree
+func checkSql(d Dialect, resp *SqlBoolQueryResp) error {
+	depth := 0
+	inString := false
+	questions := 0
+	var code strings.Builder
+
+	for _, c := range resp.Sql {
+		if c == '\'' {
+			inString = !inString
+			continue
+		}
+
+		if inString {
+			continue
+		}
+
+		code.WriteRune(c)
+
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '?':
+			questions++
+		}
+
+		if depth < 0 {
+			return errors.New("unbalanced )")
+		}
+	}
+
+	if depth != 0 || inString {
+		return errors.New("unbalanced (")
+	}
+
+	if !d.Positional() || d == Postgres {
+		prefix := ":p"
+
+		if d == Postgres {
+			prefix = "$"
+		}
+
+		used := make([]bool, len(resp.Args)+1)
+
+		for _, m := range regexp.MustCompile(regexp.QuoteMeta(prefix)+`(\d+)`).FindAllStringSubmatch(code.String(), -1) {
+			i, _ := strconv.Atoi(m[1])
+
+			if i < 1 || i > len(resp.Args) {
+				return fmt.Errorf("placeholder %s has no arg", m[0])
+			}
+
+			used[i] = true
+		}
+
+		if slices.Contains(used[1:], false) {
+			return errors.New("arg has no placeholder")
+		}
+
+		return nil
+	}
+
+	if questions != len(resp.Args) {
+		return fmt.Errorf("%d placeholders for %d args", questions, len(resp.Args))
+	}
+
+	return nil
+}
+
+func TestTemplate(t *testing.T) {
+	fields := Fields{"": "gene_symbol", "symbol": "gene_symbol", "species": "species", "score": "score"}
+
+	tmpl, err := ParseTemplate(`symbol:$gene AND species:=$sp AND score>=$min AND NOT (symbol:$gene*, "$gene")`, WithFields(fields))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(tmpl.Params(), []string{"gene", "sp", "min"}) {
+		t.Errorf("Params() = %v", tmpl.Params())
+	}
+
+	// templates are stored as json
+	data, err := json.Marshal(tmpl)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored Template
+
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.String() != tmpl.String() {
+		t.Errorf("stored template = %s; want %s", &stored, tmpl)
+	}
+
+	// values are literals so wildcards and syntax do not leak
+	resp, err := stored.SqlQuery(map[string]string{"gene": "BCL*) OR (1", "sp": "human", "min": "0.5"}, nil, WithFields(fields))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `gene_symbol LIKE :p1 ESCAPE '\' AND species LIKE :p2 ESCAPE '\' AND score >= :p3 AND NOT (gene_symbol LIKE :p4 ESCAPE '\' OR gene_symbol LIKE :p5 ESCAPE '\')`
+
+	if resp.Sql != want {
+		t.Errorf("SqlQuery = %s; want %s", resp.Sql, want)
+	}
+
+	if !slices.Equal(resp.Args, []any{"%BCL*) OR (1%", "human", 0.5, "BCL*) OR (1%", "%$gene%"}) {
+		t.Errorf("args = %v", resp.Args)
+	}
+
+	errs := []struct {
+		values map[string]string
+		err    error
+	}{
+		{map[string]string{"gene": "BCL6", "sp": "human"}, ErrMissingParam},
+		{map[string]string{"gene": "BCL6", "sp": "human", "min": "1", "max": "2"}, ErrUnknownParam},
+		{map[string]string{"gene": "BCL6", "sp": "human", "min": "high"}, ErrInvalidParam},
+		{map[string]string{"gene": "", "sp": "human", "min": "1"}, ErrInvalidParam},
+	}
+
+	for _, test := range errs {
+		if _, err := tmpl.Bind(test.values); !errors.Is(err, test.err) {
+			t.Errorf("Bind(%v) = %v; want %v", test.values, err, test.err)
+		}
+	}
+
+	// without ParseTemplate $ is a plain char
+	tree, err := SqlBoolTree("$gene")
+
+	if err != nil || tree.String() != "$gene" {
+		t.Errorf("SqlBoolTree($gene) = %v, %v", tree, err)
+	}
 
-	if resp.Sql != "((?1 AND ?2) OR (=?3 AND (?4 AND ?5)))" {
-		t.Errorf("SqlBoolQuery = %s; want %s", resp.Sql, "((?1 AND ?2) OR (?3 AND (?4 AND ?5)))")
+	if _, err := NewSqlBuilder(nil, WithFields(fields)).Build(tmpl.Tree()); !errors.Is(err, ErrMissingParam) {
+		t.Errorf("Build(template) = %v; want ErrMissingParam", err)
 	}
 }
@@ -0,0 +1,144 @@
+package query
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/antonybholmes/go-sys"
+)
+
+// String renders the term in the query syntax so that parsing the
+// result gives the same term. Exact terms are quoted with = in front
+// and chars that would otherwise be read as syntax are escaped.
+func (v *SearchTermNode) String() string {
+	if v.MatchType == MatchTypeExact {
+		return fieldPrefix(v.Field) + "=" + quote(v.Value)
+	}
+
+	return fieldPrefix(v.Field) + renderGlob(v.Value)
+}
+
+func (n *NotNode) String() string {
+	if isLeaf(n.Child) {
+		return "-" + n.Child.String()
+	}
+
+	return "-(" + n.Child.String() + ")"
+}
+
+// ANDs bind tighter than ORs and both are left associative, so
+// parens are only needed for ORs and for right hand operands that
+// are not terms or negations
+func (a *AndNode) String() string {
+	left := a.Left.String()
+
+	switch l := a.Left.(type) {
+	case *OrNode:
+		left = "(" + left + ")"
+	case *InNode:
+		if len(l.Values) > 1 {
+			left = "(" + left + ")"
+		}
+	}
+
+	right := a.Right.String()
+
+	if _, ok := a.Right.(*NotNode); !ok && !isLeaf(a.Right) {
+		right = "(" + right + ")"
+	}
+
+	return left + "+" + right
+}
+
+func (o *OrNode) String() string {
+	right := o.Right.String()
+
+	if _, ok := o.Right.(*OrNode); ok {
+		right = "(" + right + ")"
+	}
+
+	return o.Left.String() + "," + right
+}
+
+func (c *ComparisonNode) String() string {
+	return c.Field + string(c.Op) + formatNumber(c.Value)
+}
+
+func (r *RangeNode) String() string {
+	s := formatNumber(r.Min) + ".." + formatNumber(r.Max)
+
+	if r.Field != "" {
+		s = r.Field + ":" + s
+	}
+
+	return s
+}
+
+// Reports whether a node is a single term rather than an operation
+func isLeaf(n Node) bool {
+	switch n.(type) {
+	case *SearchTermNode, *PhraseNode, *PrefixNode, *ProximityNode, *ComparisonNode, *RangeNode, *ParamNode:
+		return true
+	default:
+		return false
+	}
+}
+
+// Renders a contains or pattern value, which is in the canonical
+// glob form with only wildcards escaped, as an unquoted term. Chars
+// that are not word chars, or that would make the term exact, a
+// field, a negation or a range, are escaped as are letters that
+// begin a boolean word.
+func renderGlob(value string) string {
+	var b strings.Builder
+
+	isRange := rangeRegex.MatchString(value)
+
+	escaped := false
+
+	for i, c := range value {
+		switch {
+		case escaped:
+			// copy the escaped char as is
+			escaped = false
+		case c == EscapeChar && i+1 < len(value):
+			escaped = true
+		case i == 0 && strings.ContainsRune("=^-!", c),
+			c == ':',
+			c == '.' && isRange,
+			!isWordChar(c),
+			c < 0x80 && sys.IsLetter(byte(c)) && startsBooleanWord(value, i):
+			b.WriteRune(EscapeChar)
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// Reports whether the run of letters starting at i is a boolean
+// word that normalizing could replace, in any case so that the
+// result is the same whatever the options
+func startsBooleanWord(s string, i int) bool {
+	if before, _ := utf8.DecodeLastRuneInString(s[:i]); i > 0 && isWordChar(before) {
+		return false
+	}
+
+	end := i
+
+	for end < len(s) && sys.IsLetter(s[end]) {
+		end++
+	}
+
+	if after, _ := utf8.DecodeRuneInString(s[end:]); end < len(s) && isWordChar(after) {
+		return false
+	}
+
+	switch strings.ToUpper(s[i:end]) {
+	case "AND", "OR", "NOT":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,476 @@
+package query
+
+import (
+	"slices"
+	"strings"
+)
+
+type (
+	// NormalForm is the shape Simplify can rewrite a tree into
+	NormalForm int
+
+	// InNode matches any of a list of exact values. Simplify creates
+	// them from ORs of exact terms when asked to, since a single IN
+	// is much faster than a long chain of ORs. Like the terms it
+	// replaces it ignores case, so the sql compares the lowercase
+	// column with lowercase values.
+	InNode struct {
+		Field  string
+		Values []string
+	}
+)
+
+// DefaultMaxClauses stops normal forms, which can be exponentially
+// larger than the tree, from using all the memory, e.g. the CNF of
+// (a0 AND b0) OR ... OR (a13 AND b13) has 2^14 clauses
+const DefaultMaxClauses = 1000
+
+const (
+	// leave the structure of the tree as it is
+	NormalFormNone NormalForm = iota
+	// conjunctive normal form, an AND of ORs
+	NormalFormCNF
+	// disjunctive normal form, an OR of ANDs
+	NormalFormDNF
+)
+
+// WithNormalForm makes Simplify rewrite the tree into CNF or DNF.
+// NOTs are pushed down onto the terms first. Since the tree can grow
+// exponentially, it is left in its own form if the normal form would
+// have more clauses than allowed by WithMaxClauses.
+func WithNormalForm(form NormalForm) Option {
+	return func(o *options) {
+		o.normalForm = form
+	}
+}
+
+// WithMaxClauses limits the number of clauses, the ORs of a CNF or
+// the ANDs of a DNF, that Simplify creates. It defaults to
+// DefaultMaxClauses.
+func WithMaxClauses(max int) Option {
+	return func(o *options) {
+		o.maxClauses = max
+	}
+}
+
+// WithInLists makes Simplify collapse ORs of exact terms on the
+// same field into a single IN clause. The clause is on LOWER(column)
+// so an index needs to be on the lowercase column to be used.
+func WithInLists() Option {
+	return func(o *options) {
+		o.inLists = true
+	}
+}
+
+// Simplify returns an equivalent tree without the redundancy users
+// tend to type. ANDs and ORs are flattened so that the sql is not
+// deeply nested, duplicates are removed, double negations cancel and
+// absorption is applied, e.g. A AND (A OR B) is just A. The tree
+// passed in is not modified.
+func Simplify(tree Node, opts ...Option) Node {
+	o := newOptions(opts)
+
+	tree = simplify(tree)
+
+	if o.normalForm == NormalFormCNF || o.normalForm == NormalFormDNF {
+		and := o.normalForm == NormalFormCNF
+
+		// too many clauses leaves the tree as it is
+		if clauses, ok := toClauses(negationNormal(tree, false), and, o.maxClauses); ok {
+			tree = simplify(fromClauses(clauses, and))
+		}
+	}
+
+	if o.inLists {
+		tree = inLists(tree)
+	}
+
+	return tree
+}
+
+func simplify(n Node) Node {
+	switch n := n.(type) {
+	case *NotNode:
+		child := simplify(n.Child)
+
+		if not, ok := child.(*NotNode); ok {
+			return not.Child
+		}
+
+		return &NotNode{Child: child}
+	case *AndNode:
+		return simplifyJunction(n, true)
+	case *OrNode:
+		return simplifyJunction(n, false)
+	default:
+		return n
+	}
+}
+
+func simplifyJunction(n Node, and bool) Node {
+	var ops []Node
+
+	for _, op := range flatten(n, and) {
+		// simplifying can uncover more of the same junction
+		// e.g. A AND NOT NOT (B AND C)
+		ops = append(ops, flatten(simplify(op), and)...)
+	}
+
+	return join(absorb(dedupe(ops), and), and)
+}
+
+// Returns the operands of a chain of ANDs, or ORs if and is false
+func flatten(n Node, and bool) []Node {
+	if left, right, ok := split(n, and); ok {
+		return append(flatten(left, and), flatten(right, and)...)
+	}
+
+	return []Node{n}
+}
+
+func split(n Node, and bool) (Node, Node, bool) {
+	if and {
+		if a, ok := n.(*AndNode); ok {
+			return a.Left, a.Right, true
+		}
+	} else if o, ok := n.(*OrNode); ok {
+		return o.Left, o.Right, true
+	}
+
+	return nil, nil, false
+}
+
+// Joins operands into a left deep chain, which is how the parser
+// builds them and which BuildSql renders without nesting
+func join(ops []Node, and bool) Node {
+	ret := ops[0]
+
+	for _, op := range ops[1:] {
+		if and {
+			ret = &AndNode{Left: ret, Right: op}
+		} else {
+			ret = &OrNode{Left: ret, Right: op}
+		}
+	}
+
+	return ret
+}
+
+// The canonical rendering of a node identifies it
+func key(n Node) string {
+	return n.String()
+}
+
+func dedupe(ops []Node) []Node {
+	seen := make(map[string]struct{}, len(ops))
+	ret := make([]Node, 0, len(ops))
+
+	for _, op := range ops {
+		k := key(op)
+
+		if _, ok := seen[k]; ok {
+			continue
+		}
+
+		seen[k] = struct{}{}
+		ret = append(ret, op)
+	}
+
+	return ret
+}
+
+// Applies absorption. In an AND, an OR operand is redundant if the
+// operands of some other OR, or a single term, are a subset of it,
+// e.g. A AND (A OR B) is A. The same applies to ORs of ANDs.
+func absorb(ops []Node, and bool) []Node {
+	sets := make([]map[string]struct{}, len(ops))
+
+	for i, op := range ops {
+		sets[i] = make(map[string]struct{})
+
+		for _, o := range flatten(op, !and) {
+			sets[i][key(o)] = struct{}{}
+		}
+	}
+
+	removed := make([]bool, len(ops))
+
+	for i := range ops {
+		for j := range ops {
+			if i == j || removed[j] || !isSubset(sets[j], sets[i]) {
+				continue
+			}
+
+			// of two equal sets, keep the first
+			if len(sets[j]) == len(sets[i]) && j > i {
+				continue
+			}
+
+			removed[i] = true
+			break
+		}
+	}
+
+	ret := make([]Node, 0, len(ops))
+
+	for i, op := range ops {
+		if !removed[i] {
+			ret = append(ret, op)
+		}
+	}
+
+	return ret
+}
+
+func isSubset(a map[string]struct{}, b map[string]struct{}) bool {
+	if len(a) > len(b) {
+		return false
+	}
+
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Pushes NOTs down onto the terms using De Morgan's laws
+func negationNormal(n Node, negate bool) Node {
+	switch n := n.(type) {
+	case *NotNode:
+		return negationNormal(n.Child, !negate)
+	case *AndNode:
+		left := negationNormal(n.Left, negate)
+		right := negationNormal(n.Right, negate)
+
+		if negate {
+			return &OrNode{Left: left, Right: right}
+		}
+
+		return &AndNode{Left: left, Right: right}
+	case *OrNode:
+		left := negationNormal(n.Left, negate)
+		right := negationNormal(n.Right, negate)
+
+		if negate {
+			return &AndNode{Left: left, Right: right}
+		}
+
+		return &OrNode{Left: left, Right: right}
+	default:
+		if negate {
+			return &NotNode{Child: n}
+		}
+
+		return n
+	}
+}
+
+// Converts a tree in negation normal form into clauses, which
+// are ORs of terms that are ANDed together if and is true (CNF)
+// or ANDs of terms that are ORed together otherwise (DNF). It gives
+// up as soon as there are more than max clauses.
+func toClauses(n Node, and bool, max int) ([][]Node, bool) {
+	if _, _, ok := split(n, and); ok {
+		var ret [][]Node
+
+		for _, op := range flatten(n, and) {
+			clauses, ok := toClauses(op, and, max)
+
+			if !ok {
+				return nil, false
+			}
+
+			ret = append(ret, clauses...)
+
+			if exceeds(len(ret), max) {
+				return nil, false
+			}
+		}
+
+		return ret, true
+	}
+
+	if _, _, ok := split(n, !and); ok {
+		// distribute e.g. for CNF (A AND B) OR C becomes
+		// (A OR C) AND (B OR C)
+		ret := [][]Node{{}}
+
+		for _, op := range flatten(n, !and) {
+			clauses, ok := toClauses(op, and, max)
+
+			if !ok || exceeds(len(ret)*len(clauses), max) {
+				return nil, false
+			}
+
+			product := make([][]Node, 0, len(ret)*len(clauses))
+
+			for _, clause := range ret {
+				for _, c := range clauses {
+					product = append(product, append(slices.Clone(clause), c...))
+				}
+			}
+
+			ret = product
+		}
+
+		return ret, true
+	}
+
+	return [][]Node{{n}}, true
+}
+
+func fromClauses(clauses [][]Node, and bool) Node {
+	ops := make([]Node, 0, len(clauses))
+
+	for _, clause := range clauses {
+		ops = append(ops, join(clause, !and))
+	}
+
+	return join(ops, and)
+}
+
+// Collapses the exact terms of each OR into IN nodes, one per field
+func inLists(n Node) Node {
+	switch n := n.(type) {
+	case *NotNode:
+		return &NotNode{Child: inLists(n.Child)}
+	case *AndNode:
+		return &AndNode{Left: inLists(n.Left), Right: inLists(n.Right)}
+	case *OrNode:
+		ops := flatten(n, false)
+		ret := make([]Node, 0, len(ops))
+
+		// where each field's IN node is in ret
+		fields := make(map[string]int)
+
+		for _, op := range ops {
+			field, values, ok := exactValues(op)
+
+			if !ok {
+				ret = append(ret, inLists(op))
+				continue
+			}
+
+			i, ok := fields[field]
+
+			if !ok {
+				fields[field] = len(ret)
+				ret = append(ret, &InNode{Field: field, Values: values})
+				continue
+			}
+
+			in := ret[i].(*InNode)
+
+			for _, v := range values {
+				if !slices.Contains(in.Values, v) {
+					in.Values = append(in.Values, v)
+				}
+			}
+		}
+
+		// IN nodes with one value are left as terms
+		for i, op := range ret {
+			if in, ok := op.(*InNode); ok && len(in.Values) == 1 {
+				ret[i] = &SearchTermNode{Field: in.Field, Value: in.Values[0], MatchType: MatchTypeExact}
+			}
+		}
+
+		return join(ret, false)
+	default:
+		return n
+	}
+}
+
+func exactValues(n Node) (string, []string, bool) {
+	switch n := n.(type) {
+	case *SearchTermNode:
+		if n.MatchType == MatchTypeExact {
+			return n.Field, []string{n.Value}, true
+		}
+	case *InNode:
+		return n.Field, slices.Clone(n.Values), true
+	}
+
+	return "", nil, false
+}
+
+// The ORs of exact terms the node stands for
+func (n *InNode) or() Node {
+	ops := make([]Node, 0, len(n.Values))
+
+	for _, v := range n.Values {
+		ops = append(ops, &SearchTermNode{Field: n.Field, Value: v, MatchType: MatchTypeExact})
+	}
+
+	return join(ops, false)
+}
+
+// BuildSql creates e.g. LOWER(gene_symbol) IN (:p1, :p2). If the builder
+// has a custom clause function, it cannot know how to write an IN
+// so the ORs of terms are built instead.
+func (n *InNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	if b.customClause {
+		return n.or().BuildSql(b, addParens)
+	}
+
+	columns := b.columns
+
+	if n.Field != "" {
+		columns = []string{b.column(n.Field)}
+	} else if b.fields != nil {
+		if column, ok := b.fields.Column(""); ok {
+			columns = []string{column}
+		}
+	}
+
+	if len(columns) == 0 {
+		b.fail(errNoColumns)
+		return ""
+	}
+
+	indexes := make([]int, 0, len(n.Values))
+
+	for _, v := range n.Values {
+		indexes = append(indexes, b.addArg(strings.ToLower(v)))
+	}
+
+	clauses := make([]string, 0, len(columns))
+
+	for i, c := range columns {
+		placeholders := make([]string, 0, len(indexes))
+
+		for _, index := range indexes {
+			placeholders = append(placeholders, b.placeholder(index, i))
+		}
+
+		clauses = append(clauses, "LOWER("+c+") IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+
+	return AddParens(strings.Join(clauses, " OR "), addParens)
+}
+
+func (n *InNode) Eval(match Matcher) bool {
+	for _, v := range match(n.Field) {
+		v = strings.ToLower(v)
+
+		for _, value := range n.Values {
+			if strings.ToLower(value) == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// String renders the ORs the node replaced
+func (n *InNode) String() string {
+	return n.or().String()
+}
@@ -0,0 +1,187 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errNoColumns = errors.New("no columns to search")
+
+type (
+	// SqlCompareClauseFunc creates the sql for a numeric comparison
+	// of a column, e.g. score > :p1, where op is one of >, >=, < or <=
+	SqlCompareClauseFunc func(placeholderIndex int, column string, op CompareOp, addParens bool) string
+
+	// SqlBuilder holds the callbacks and state used whilst
+	// building the sql for a tree
+	SqlBuilder struct {
+		err     error
+		dialect Dialect
+		clause  SqlFieldClauseFunc
+		// true if the caller supplied the clause
+		customClause bool
+		escapeLike   bool
+		compare      SqlCompareClauseFunc
+		fields       Fields
+		columns      []string
+		args         []any
+	}
+)
+
+// NewSqlBuilder creates a builder that uses clause to create the
+// sql for each search term. If clause is nil, terms are matched
+// with the case insensitive LIKE of the dialect against the column
+// of their field or, for plain terms, the columns from WithColumns.
+// Numeric comparisons use the compare clause from the options, or
+// column op placeholder by default. The dialect defaults to Sqlite.
+func NewSqlBuilder(clause SqlFieldClauseFunc, opts ...Option) *SqlBuilder {
+	o := newOptions(opts)
+
+	b := &SqlBuilder{
+		dialect: o.dialect,
+		clause:  clause,
+
+		customClause: clause != nil,
+		escapeLike:   clause == nil || o.escapeLike,
+		compare:      o.compare,
+		fields:       o.fields,
+		columns:      o.columns,
+		args:         make([]any, 0, 20),
+	}
+
+	if b.dialect == nil {
+		b.dialect = Sqlite
+	}
+
+	if b.clause == nil {
+		b.clause = b.likeClause
+	}
+
+	if b.compare == nil {
+		b.compare = b.compareClause
+	}
+
+	return b
+}
+
+func (b *SqlBuilder) Dialect() Dialect {
+	return b.dialect
+}
+
+// Build creates the sql for a tree. The builder can only be
+// used once since it accumulates the args.
+func (b *SqlBuilder) Build(tree Node) (*SqlBoolQueryResp, error) {
+	sql := tree.BuildSql(b, false)
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return &SqlBoolQueryResp{Sql: sql, Args: b.args}, nil
+}
+
+// Adds an arg and returns its placeholder index. As we parse
+// the tree in order, the placeholder index is just the current
+// length of the args slice as we add to it. Args must be values
+// a database/sql driver accepts, e.g. string, int64 or float64.
+func (b *SqlBuilder) addArg(arg any) int {
+	b.args = append(b.args, arg)
+	return len(b.args)
+}
+
+// Returns the sql column of a field if the builder has fields,
+// otherwise the field as is if it is a plain identifier, since trees
+// from json or the builder can have any field and it is written into
+// the sql. Unknown fields are recorded as an error since nodes cannot
+// return errors from BuildSql.
+func (b *SqlBuilder) column(field string) string {
+	if b.fields == nil {
+		for _, c := range field {
+			if !isFieldChar(c) {
+				b.fail(fmt.Errorf("%w: %q is not a column", ErrUnknownField, field))
+				return ""
+			}
+		}
+
+		return field
+	}
+
+	column, ok := b.fields.Column(field)
+
+	if !ok {
+		b.fail(fmt.Errorf("%w: %s", ErrUnknownField, field))
+	}
+
+	return column
+}
+
+// Records the first error encountered
+func (b *SqlBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Returns the placeholder for an arg that has already been added.
+// Positional placeholders cannot be reused, so after the first use
+// the arg is added again for each further use.
+func (b *SqlBuilder) placeholder(placeholderIndex int, use int) string {
+	if use > 0 && b.dialect.Positional() {
+		placeholderIndex = b.addArg(b.args[placeholderIndex-1])
+	}
+
+	return b.dialect.Placeholder(placeholderIndex)
+}
+
+// The default term clause, e.g. (gene_symbol ILIKE $1 ESCAPE '\' OR ...)
+func (b *SqlBuilder) likeClause(placeholderIndex int, column string, value string, addParens bool) string {
+	columns := b.columns
+
+	if column != "" {
+		columns = []string{column}
+	}
+
+	if len(columns) == 0 {
+		b.fail(errNoColumns)
+		return ""
+	}
+
+	clauses := make([]string, 0, len(columns))
+
+	for i, c := range columns {
+		clauses = append(clauses, c+" "+b.dialect.Like()+" "+b.placeholder(placeholderIndex, i)+" "+b.dialect.Escape())
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+
+	return AddParens(strings.Join(clauses, " OR "), addParens)
+}
+
+// Builds the clause for a term of a field given its LIKE pattern.
+// Plain terms use the default column if there is one, otherwise
+// the clause is free to search what it likes. Clauses that may not
+// have an ESCAPE clause get the pattern without its escapes.
+func (b *SqlBuilder) termClause(field string, pattern string, addParens bool) string {
+	if !b.escapeLike {
+		pattern = unescape(pattern)
+	}
+
+	column := ""
+
+	if field != "" {
+		column = b.column(field)
+	} else if b.fields != nil {
+		column, _ = b.fields.Column("")
+	}
+
+	placeholderIndex := b.addArg(pattern)
+
+	return b.clause(placeholderIndex, column, pattern, addParens)
+}
+
+func (b *SqlBuilder) compareClause(placeholderIndex int, column string, op CompareOp, addParens bool) string {
+	return column + " " + string(op) + " " + b.placeholder(placeholderIndex, 0)
+}
@@ -0,0 +1,233 @@
+//go:build cgo
+
+package query
+
+import (
+	"database/sql"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The sqlite driver needs cgo so these tests only build with it
+
+// Opens an in memory database that is closed when the test ends
+func openSqlite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	// each connection has its own in memory database
+	db.SetMaxOpenConns(1)
+
+	return db
+}
+
+// randomDiffTree creates trees that the sql and Eval should agree
+// on. The values are ASCII since SQLite LIKE only folds the case of
+// ASCII, and there are no proximity terms since LIKE cannot measure
+// how far apart words are.
+func randomDiffTree(r *rand.Rand, depth int) Node {
+	if depth == 0 || r.IntN(3) == 0 {
+		value := randomDiffValue(r)
+
+		if value == "" {
+			value = "a"
+		}
+
+		switch r.IntN(7) {
+		case 0:
+			ops := []CompareOp{OpGt, OpGe, OpLt, OpLe}
+			return Compare("score", ops[r.IntN(len(ops))], float64(r.IntN(12)-6)/2)
+		case 1:
+			min := float64(r.IntN(12) - 6)
+			return Between("score", min/2, (min+float64(r.IntN(6)))/2)
+		case 2:
+			return Exact(value)
+		case 3:
+			return Pattern(value + "*")
+		case 4:
+			return Prefix(value)
+		case 5:
+			return Phrase(value)
+		default:
+			return Term(value)
+		}
+	}
+
+	switch r.IntN(3) {
+	case 0:
+		return Not(randomDiffTree(r, depth-1))
+	case 1:
+		return And(randomDiffTree(r, depth-1), randomDiffTree(r, depth-1))
+	default:
+		return Or(randomDiffTree(r, depth-1), randomDiffTree(r, depth-1))
+	}
+}
+
+func randomDiffValue(r *rand.Rand) string {
+	chars := []string{"a", "b", "A", "B", " ", "_", "%", "?", `\`, `"`}
+
+	var value string
+
+	for range r.IntN(4) {
+		value += chars[r.IntN(len(chars))]
+	}
+
+	return value
+}
+
+// The sql run by SQLite must select the same rows as Eval
+func TestSqliteEval(t *testing.T) {
+	db := openSqlite(t)
+
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, c TEXT NOT NULL, score REAL NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+
+	type row struct {
+		c     string
+		score float64
+	}
+
+	rows := make([]row, 200)
+
+	for i := range rows {
+		rows[i] = row{randomDiffValue(r) + randomDiffValue(r), float64(r.IntN(12)-6) / 2}
+
+		if _, err := db.Exec("INSERT INTO t (id, c, score) VALUES (?, ?, ?)", i, rows[i].c, rows[i].score); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fields := Fields{"": "c", "score": "score"}
+
+	for range 500 {
+		tree := randomDiffTree(r, 3)
+
+		// IN lists ignore case in both
+		if r.IntN(4) == 0 {
+			tree = Simplify(tree, WithInLists())
+		}
+
+		resp, err := NewSqlBuilder(nil, WithFields(fields)).Build(tree)
+
+		if err != nil {
+			t.Fatalf("Build(%s) error: %v", tree, err)
+		}
+
+		res, err := db.Query("SELECT id FROM t WHERE "+resp.Sql+" ORDER BY id", resp.Named()...)
+
+		if err != nil {
+			t.Fatalf("Query(%s) error: %v", resp.Sql, err)
+		}
+
+		var got []int
+
+		for res.Next() {
+			var id int
+
+			if err := res.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, id)
+		}
+
+		res.Close()
+
+		var want []int
+
+		for i, row := range rows {
+			match := MatchFields(map[string][]string{"": {row.c}, "score": {formatNumber(row.score)}})
+
+			if tree.Eval(match) {
+				want = append(want, i)
+			}
+		}
+
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: sql %s %v selects %v; Eval selects %v", tree, resp.Sql, resp.Args, got, want)
+		}
+	}
+}
+
+// Clauses from the caller without an ESCAPE clause must keep working
+// in SQLite, which has no default escape char, so their patterns are
+// only escaped with WithLikeEscape
+func TestSqliteClauses(t *testing.T) {
+	db := openSqlite(t)
+
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, c TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	values := []string{"HLA_A", "HLAXA", "50%", "500"}
+
+	for i, v := range values {
+		if _, err := db.Exec("INSERT INTO t (id, c) VALUES (?, ?)", i, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plain := func(placeholderIndex int, value string, addParens bool) string {
+		return "c LIKE " + IndexedParam(placeholderIndex)
+	}
+
+	escaped := func(placeholderIndex int, value string, addParens bool) string {
+		return "c LIKE " + IndexedParam(placeholderIndex) + " " + Sqlite.Escape()
+	}
+
+	tests := []struct {
+		query  string
+		clause SqlClauseFunc
+		opts   []Option
+		want   []string
+	}{
+		{"HLA_A", plain, nil, []string{"HLA_A", "HLAXA"}},
+		{"50%", plain, nil, []string{"50%", "500"}},
+		{"HLA_A", escaped, []Option{WithLikeEscape()}, []string{"HLA_A"}},
+		{"50%", escaped, []Option{WithLikeEscape()}, []string{"50%"}},
+		{"HLA_A", nil, []Option{WithColumns("c")}, []string{"HLA_A"}},
+	}
+
+	for _, test := range tests {
+		resp, err := SqlBoolQuery(test.query, test.clause, test.opts...)
+
+		if err != nil {
+			t.Fatalf("SqlBoolQuery(%q) error: %v", test.query, err)
+		}
+
+		res, err := db.Query("SELECT c FROM t WHERE "+resp.Sql+" ORDER BY id", resp.Named()...)
+
+		if err != nil {
+			t.Fatalf("Query(%s) error: %v", resp.Sql, err)
+		}
+
+		var got []string
+
+		for res.Next() {
+			var c string
+
+			if err := res.Scan(&c); err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, c)
+		}
+
+		res.Close()
+
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%q: sql %s %v selects %v; want %v", test.query, resp.Sql, resp.Args, got, test.want)
+		}
+	}
+}
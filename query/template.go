@@ -0,0 +1,454 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type (
+	// ParamNode is a placeholder in a template, e.g. $gene in
+	// symbol:$gene, that Template.Bind replaces with Target given
+	// the value of the param. Target is the node without its value,
+	// a SearchTermNode for $gene and =$gene, a PrefixNode for $gene*
+	// or a ComparisonNode for score>$min.
+	ParamNode struct {
+		Name   string
+		Target Node
+	}
+
+	// Template is a query with params, e.g. symbol:$gene AND
+	// species:$sp, that is parsed and validated once, so that it can
+	// be stored, and bound to values later. Values are substituted
+	// into the tree, not the query, so they are always literals.
+	Template struct {
+		tree   Node
+		params []string
+	}
+
+	paramJSON struct {
+		Type   string          `json:"type"`
+		Name   string          `json:"name"`
+		Target json.RawMessage `json:"target"`
+	}
+)
+
+var (
+	// ErrMissingParam is returned when a param has no value
+	ErrMissingParam = errors.New("missing param")
+
+	// ErrUnknownParam is returned when binding a value to a
+	// param the template does not have
+	ErrUnknownParam = errors.New("unknown param")
+
+	// ErrInvalidParam is returned when a value cannot be bound,
+	// e.g. a comparison param that is not a number
+	ErrInvalidParam = errors.New("invalid param")
+)
+
+// Lets the parser create params, which only templates have so
+// that $ stays a plain char in queries
+func withParams() Option {
+	return func(o *options) {
+		o.params = true
+	}
+}
+
+// ParseTemplate parses a query with params. A param is $ and a name
+// of letters, digits and underscores in place of a value: $gene
+// matches values containing the value, =$gene the whole value and
+// $gene* values starting with it, and score>$min is a comparison.
+// Quoted and escaped params, e.g. "$gene" or \$gene, are literals.
+func ParseTemplate(query string, opts ...Option) (*Template, error) {
+	tree, err := SqlBoolTree(query, append(opts, withParams())...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTemplate(tree)
+}
+
+// NewTemplate creates a template from a tree, e.g. one unmarshaled
+// from json
+func NewTemplate(tree Node) (*Template, error) {
+	if tree == nil {
+		return nil, errMissingNode
+	}
+
+	t := &Template{tree: tree}
+
+	if err := t.collectParams(tree); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Finds the names of the params in the order they are first used
+func (t *Template) collectParams(n Node) error {
+	switch n := n.(type) {
+	case *NotNode:
+		return t.collectParams(n.Child)
+	case *AndNode:
+		if err := t.collectParams(n.Left); err != nil {
+			return err
+		}
+
+		return t.collectParams(n.Right)
+	case *OrNode:
+		if err := t.collectParams(n.Left); err != nil {
+			return err
+		}
+
+		return t.collectParams(n.Right)
+	case *ParamNode:
+		if !isParamName(n.Name) {
+			return fmt.Errorf("%w: name %q", ErrInvalidParam, n.Name)
+		}
+
+		switch n.Target.(type) {
+		case *SearchTermNode, *PrefixNode, *ComparisonNode:
+		default:
+			return fmt.Errorf("%w: %s cannot be bound to %T", ErrInvalidParam, n.Name, n.Target)
+		}
+
+		if !slices.Contains(t.params, n.Name) {
+			t.params = append(t.params, n.Name)
+		}
+	}
+
+	return nil
+}
+
+// Params returns the names of the params
+func (t *Template) Params() []string {
+	return slices.Clone(t.params)
+}
+
+// Tree returns the tree of the template, which has ParamNodes
+// where the params are
+func (t *Template) Tree() Node {
+	return t.tree
+}
+
+// Bind returns a copy of the tree with each param replaced by a node
+// for its value. Every param must have a value and there must be no
+// values for params the template does not have.
+func (t *Template) Bind(values map[string]string) (Node, error) {
+	for name := range values {
+		if !slices.Contains(t.params, name) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownParam, name)
+		}
+	}
+
+	return bind(t.tree, values)
+}
+
+// SqlQuery binds the values and builds the sql for the tree,
+// see SqlFieldBoolQueryFromTree
+func (t *Template) SqlQuery(values map[string]string, clause SqlFieldClauseFunc, opts ...Option) (*SqlBoolQueryResp, error) {
+	tree, err := t.Bind(values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return SqlFieldBoolQueryFromTree(tree, clause, opts...)
+}
+
+// String renders the template in the query syntax
+func (t *Template) String() string {
+	return t.tree.String()
+}
+
+// MarshalJSON stores the template as the json of its tree
+func (t *Template) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.tree)
+}
+
+func (t *Template) UnmarshalJSON(data []byte) error {
+	tree, err := UnmarshalNode(data)
+
+	if err != nil {
+		return err
+	}
+
+	ret, err := NewTemplate(tree)
+
+	if err != nil {
+		return err
+	}
+
+	*t = *ret
+
+	return nil
+}
+
+// Copies a tree replacing the params with their values so that
+// the template is left as it is
+func bind(n Node, values map[string]string) (Node, error) {
+	switch n := n.(type) {
+	case *NotNode:
+		child, err := bind(n.Child, values)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &NotNode{Child: child}, nil
+	case *AndNode:
+		left, right, err := bindBoth(n.Left, n.Right, values)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &AndNode{Left: left, Right: right}, nil
+	case *OrNode:
+		left, right, err := bindBoth(n.Left, n.Right, values)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &OrNode{Left: left, Right: right}, nil
+	case *ParamNode:
+		value, ok := values[n.Name]
+
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingParam, n.Name)
+		}
+
+		return n.bind(value)
+	default:
+		// the other nodes have no params and, since nothing
+		// changes trees, can be shared with the template
+		return n, nil
+	}
+}
+
+func bindBoth(left Node, right Node, values map[string]string) (Node, Node, error) {
+	l, err := bind(left, values)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := bind(right, values)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l, r, nil
+}
+
+// Creates the node for a value, which is a literal
+func (n *ParamNode) bind(value string) (Node, error) {
+	if c, ok := n.Target.(*ComparisonNode); ok {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+
+		if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+			return nil, fmt.Errorf("%w: %s must be a number", ErrInvalidParam, n.Name)
+		}
+
+		return &ComparisonNode{Field: c.Field, Op: c.Op, Value: number}, nil
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("%w: %s is empty", ErrInvalidParam, n.Name)
+	}
+
+	switch t := n.Target.(type) {
+	case *PrefixNode:
+		return &PrefixNode{Field: t.Field, Value: value}, nil
+	case *SearchTermNode:
+		if t.MatchType == MatchTypeExact {
+			return Exact(value).On(t.Field), nil
+		}
+
+		return Term(value).On(t.Field), nil
+	default:
+		return nil, fmt.Errorf("%w: %s cannot be bound to %T", ErrInvalidParam, n.Name, n.Target)
+	}
+}
+
+// Parses a param at the current position if there is one, e.g. $gene,
+// =$gene or $gene*. It must be the whole term so that $gene-x is
+// still a plain term.
+func (p *Parser) parseParam(field string) (Node, bool, error) {
+	if !p.opts.params {
+		return nil, false, nil
+	}
+
+	start := p.pos
+	exact := p.peek() == '='
+
+	if exact {
+		p.next()
+	}
+
+	name, ok := p.paramName()
+
+	if !ok {
+		p.pos = start
+		return nil, false, nil
+	}
+
+	prefix := p.peek() == '*'
+
+	if prefix {
+		p.next()
+	}
+
+	if isWordChar(p.peek()) {
+		p.pos = start
+		return nil, false, nil
+	}
+
+	var target Node
+
+	switch {
+	case exact && prefix:
+		return nil, true, p.errorAt(start, errors.New("cannot have wildcards in exact match"))
+	case exact:
+		target = &SearchTermNode{Field: field, MatchType: MatchTypeExact}
+	case prefix:
+		target = &PrefixNode{Field: field}
+	default:
+		target = &SearchTermNode{Field: field, MatchType: MatchTypeContains}
+	}
+
+	return &ParamNode{Name: name, Target: target}, true, nil
+}
+
+// Parses $name at the current position
+func (p *Parser) paramName() (string, bool) {
+	if p.peek() != '$' {
+		return "", false
+	}
+
+	start := p.pos + 1
+	end := start
+
+	for end < len(p.input) && isFieldChar(rune(p.input[end])) {
+		end++
+	}
+
+	if end == start {
+		return "", false
+	}
+
+	p.pos = end
+
+	return p.input[start:end], true
+}
+
+func isParamName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if !isFieldChar(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Params are not bound so the sql cannot be built
+func (n *ParamNode) BuildSql(b *SqlBuilder, addParens bool) string {
+	b.fail(fmt.Errorf("%w: %s", ErrMissingParam, n.Name))
+	return ""
+}
+
+// Params match nothing until they are bound
+func (n *ParamNode) Eval(match Matcher) bool {
+	return false
+}
+
+func (n *ParamNode) String() string {
+	switch t := n.Target.(type) {
+	case *ComparisonNode:
+		return t.Field + string(t.Op) + "$" + n.Name
+	case *PrefixNode:
+		return fieldPrefix(t.Field) + "$" + n.Name + "*"
+	case *SearchTermNode:
+		if t.MatchType == MatchTypeExact {
+			return fieldPrefix(t.Field) + "=$" + n.Name
+		}
+
+		return fieldPrefix(t.Field) + "$" + n.Name
+	default:
+		return "$" + n.Name
+	}
+}
+
+func (n *ParamNode) MarshalJSON() ([]byte, error) {
+	target, err := json.Marshal(n.Target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(paramJSON{Type: NodeTypeParam, Name: n.Name, Target: target})
+}
+
+func (n *ParamNode) UnmarshalJSON(data []byte) error {
+	var t paramJSON
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	target, err := unmarshalTarget(t.Target)
+
+	if err != nil {
+		return err
+	}
+
+	*n = ParamNode{Name: t.Name, Target: target}
+
+	return nil
+}
+
+// The target has no value so it is unmarshaled directly rather
+// than with UnmarshalNode, which would reject the empty value
+func unmarshalTarget(data json.RawMessage) (Node, error) {
+	var head struct {
+		Type  string    `json:"type"`
+		Field string    `json:"field"`
+		Match MatchType `json:"match"`
+		Op    CompareOp `json:"op"`
+	}
+
+	if len(data) == 0 || string(data) == "null" {
+		return nil, errMissingNode
+	}
+
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+
+	switch head.Type {
+	case NodeTypeTerm:
+		return &SearchTermNode{Field: head.Field, MatchType: head.Match}, nil
+	case NodeTypePrefix:
+		return &PrefixNode{Field: head.Field}, nil
+	case NodeTypeCompare:
+		if err := checkComparison(head.Field, head.Op); err != nil {
+			return nil, err
+		}
+
+		return &ComparisonNode{Field: head.Field, Op: head.Op}, nil
+	default:
+		return nil, fmt.Errorf("%w: cannot bind to %q", ErrInvalidParam, head.Type)
+	}
+}